@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	batchInput      string
+	batchColumn     string
+	batchOutputFile string
+)
+
+var BatchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "Geo-locate every query in a CSV file, writing the rows back with their coordinates",
+	Long: "Geo-locate every query in a CSV file, writing the rows back with their coordinates.\n\n" +
+		"The input must have a header row. Each row keeps its original columns and gains: " +
+		strings.Join(internalcmd.BatchColumns, ", ") + ".\n" +
+		"When a query has several matches, the first one is used.",
+	Example: "  geo batch --input places.csv --column address --output-file places-geo.csv\n" +
+		"  cut -d, -f3 customers.csv | geo batch > customers-geo.csv",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("output") && outputFormat != "csv" {
+			fmt.Fprintf(os.Stderr, "'geo batch' always writes CSV, '--output %s' is not supported.\n", outputFormat)
+			os.Exit(ExitUsage)
+		}
+
+		input := mustReadBatch()
+
+		var w io.Writer = os.Stdout
+		if batchOutputFile != "-" {
+			f, err := os.Create(batchOutputFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to create '%s': %s\n", batchOutputFile, err)
+				os.Exit(ExitError)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		out, err := internalcmd.NewBatchWriter(w, input.Header, relax, providerColumns[provider])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write results: %s\n", err)
+			os.Exit(ExitError)
+		}
+
+		g := newGeocoder()
+		s := newSummary()
+
+		queries := make([]string, len(input.Rows))
+		for i := range input.Rows {
+			queries[i] = input.Query(i)
+		}
+
+		cached := withRelax(withFilters(withCache(resolveScope(), batchResolver(cmd.Context(), g, queries))))
+		resolve := func(ctx context.Context, query string) (internalcmd.Lookup, error) {
+			if query == "" {
+				return internalcmd.Lookup{}, nil
+			}
+			return cached(ctx, query)
+		}
+
+		for i, result := range internalcmd.ResolveAll(cmd.Context(), resolve, queries, parallel) {
+			if errors.Is(result.Err, internalcmd.ErrUnauthorized) {
+				printUnauthorized()
+				os.Exit(ExitAuth)
+			}
+
+			status := internalcmd.BatchOK
+			switch {
+			case queries[i] == "":
+				status = internalcmd.BatchEmpty
+			case result.Err != nil:
+				fmt.Fprintf(os.Stderr, "unexpected error when getting the location '%s': %s\n", queries[i], result.Err)
+				status = internalcmd.BatchError
+			case len(result.Lookup.Records) == 0:
+				status = internalcmd.BatchNoMatch
+			}
+			s.add(queries[i], status, result.Err)
+			writeBatchRow(out, input.Rows[i], status, result.Lookup)
+		}
+
+		// Each row has its status in the output already, so only the totals are reported.
+		s.writeTotals(os.Stderr)
+		if code := s.exitCode(); code != ExitOK {
+			os.Exit(code)
+		}
+	},
+}
+
+// batchResolver resolves the queries of a batch with g. When g can search in batches, such as the Census geocoder,
+// every query the cache cannot answer is searched for up front, in as few requests as g needs.
+func batchResolver(ctx context.Context, g internalcmd.Geocoder, queries []string) internalcmd.Resolver {
+	bg, ok := g.(internalcmd.BatchGeocoder)
+	if !ok {
+		return newResolver(g)
+	}
+	return internalcmd.NewBatchResolver(ctx, bg, uncachedQueries(resolveScope(), queries), mustDefaultCountry())
+}
+
+// uncachedQueries returns the queries the cache cannot answer, which is all of them with '--no-cache' or '--refresh'.
+func uncachedQueries(scope string, queries []string) []string {
+	if noCache || refreshCache || openCache() != nil {
+		return queries
+	}
+	var uncached []string
+	for _, query := range queries {
+		if _, found, err := cache.Get(internalcmd.CacheKey(scope, query)); err != nil || !found {
+			uncached = append(uncached, query)
+		}
+	}
+	return uncached
+}
+
+// mustReadBatch reads the '--input' document, or exits when it cannot be used.
+func mustReadBatch() *internalcmd.BatchInput {
+	var r io.Reader = os.Stdin
+	if batchInput != "-" {
+		f, err := os.Open(batchInput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open '%s': %s\n", batchInput, err)
+			os.Exit(ExitError)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	input, err := internalcmd.ReadBatch(r, batchColumn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read '%s': %s\n", batchInput, err)
+		os.Exit(ExitError)
+	}
+	return input
+}
+
+func writeBatchRow(out *internalcmd.BatchWriter, row []string, status internalcmd.BatchStatus, lookup internalcmd.Lookup) {
+	if err := out.Write(row, status, lookup); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write results for '%s': %s\n", lookup.Query, err)
+		os.Exit(ExitError)
+	}
+}
+
+func init() {
+	BatchCmd.Flags().StringVar(&batchInput, "input", "-", "CSV file of queries, '-' reads standard input")
+	BatchCmd.Flags().StringVar(&batchColumn, "column", "", "header of the column holding the queries, optional when the input has one column")
+	BatchCmd.Flags().BoolVar(&relax, "relax", false, "retry addresses that match nothing without their street, county, then neighbourhood, adding geo_relaxed and geo_precision columns")
+	BatchCmd.Flags().StringVar(&batchOutputFile, "output-file", "-", "file to write the enriched CSV to, '-' writes standard output")
+}
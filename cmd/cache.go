@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// resolveScope returns the cache scope of lookups made by 'geo' and 'geo batch', which differ by provider, country, limit and language.
+func resolveScope() string {
+	code := mustDefaultCountry()
+	if code == "" {
+		code = noCountry
+	}
+	scope := providerScope() + ":resolve:country=" + code
+	if nameLimit > 0 {
+		scope += fmt.Sprintf(":limit=%d", nameLimit)
+	}
+	return scope + langScope()
+}
+
+// providerScope returns the part of a cache scope naming the '--provider', along with the server it calls and the
+// options that change its results, so lookups made against a stub, a proxy or a self-hosted server are never served
+// for another. Defaults are left out, so the scope of the usual server does not change as options are added.
+func providerScope() string {
+	scope := provider
+	switch provider {
+	case "openweather":
+		scope += optionScope("url", apiUrl, internalcmd.DefaultBaseURL)
+	case "nominatim":
+		scope += optionScope("url", nominatim.BaseURL, internalcmd.DefaultNominatimURL)
+	case "census":
+		scope += optionScope("url", census.BaseURL, internalcmd.DefaultCensusURL) +
+			optionScope("benchmark", census.Benchmark, internalcmd.DefaultCensusBenchmark) +
+			optionScope("vintage", census.Vintage, internalcmd.DefaultCensusVintage)
+	case "offline":
+		defaultDbPath, _ := internalcmd.DefaultGeoNamesPath()
+		scope += optionScope("db", dbPath, defaultDbPath)
+	}
+	return scope
+}
+
+// optionScope returns the part of a cache scope naming an option, empty when it has its default value.
+func optionScope(name, value, defaultValue string) string {
+	if value == "" || value == defaultValue {
+		return ""
+	}
+	return "[" + name + "=" + value + "]"
+}
+
+// langScope returns the part of a cache scope naming the '--lang' of place names, if any.
+func langScope() string {
+	if lang == "" {
+		return ""
+	}
+	return ":lang=" + strings.ToLower(lang)
+}
+
+var (
+	noCache          bool
+	refreshCache     bool
+	cacheTTL         time.Duration
+	cacheNegativeTTL time.Duration
+
+	purgeOlderThan time.Duration
+	purgeQuery     string
+	purgeAll       bool
+	warmInput      string
+
+	cache *internalcmd.Cache // opened by withCache or mustOpenCache, closed by Execute
+)
+
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and manage the lookup cache",
+	Long: "Inspect and manage the lookup cache.\n\n" +
+		"Lookups are cached in $XDG_CACHE_HOME/geo/" + internalcmd.CacheFileName + ", or the platform's user cache directory " +
+		"when XDG_CACHE_HOME is not set. Every lookup command reads the cache before calling the provider.",
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the number, size and age of cached lookups",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		stats, err := mustOpenCache().Stats()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read the cache: %s\n", err)
+			os.Exit(ExitError)
+		}
+
+		fmt.Printf("Path:    %s\n", stats.Path)
+		fmt.Printf("Size:    %s\n", formatSize(stats.Size))
+		fmt.Printf("Entries: %d (%d matched, %d matched nothing, %d expired)\n", stats.Entries, stats.Matches, stats.Misses, stats.Expired)
+		if stats.Entries > 0 {
+			fmt.Printf("Oldest:  %s (%s ago)\n", stats.Oldest.Format(time.RFC3339), formatAge(time.Since(stats.Oldest)))
+			fmt.Printf("Newest:  %s (%s ago)\n", stats.Newest.Format(time.RFC3339), formatAge(time.Since(stats.Newest)))
+		}
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:     "purge",
+	Short:   "Remove cached lookups",
+	Example: "  geo cache purge --older-than 168h\n  geo cache purge --query \"Henrico, VA\"\n  geo cache purge --all",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if purgeOlderThan <= 0 && purgeQuery == "" && !purgeAll {
+			fmt.Fprintf(os.Stderr, "'geo cache purge' needs '--older-than', '--query' or '--all'.\n")
+			os.Exit(ExitUsage)
+		}
+		if purgeAll && (purgeOlderThan > 0 || purgeQuery != "") {
+			fmt.Fprintf(os.Stderr, "'--all' cannot be combined with '--older-than' or '--query'.\n")
+			os.Exit(ExitUsage)
+		}
+
+		purged, err := mustOpenCache().Purge(purgeOlderThan, purgeQuery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to purge the cache: %s\n", err)
+			os.Exit(ExitError)
+		}
+		fmt.Printf("Purged %d entries.\n", purged)
+	},
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:     "export",
+	Short:   "Write every cached lookup to standard output as JSON lines",
+	Example: "  geo cache export > lookups.jsonl",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := bufio.NewWriter(os.Stdout)
+		_, err := mustOpenCache().Export(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export the cache: %s\n", err)
+			os.Exit(ExitError)
+		}
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Add lookups written by 'geo cache export', '-' reads standard input",
+	Long: "Add lookups written by 'geo cache export', '-' reads standard input.\n\n" +
+		"Imported lookups keep their original expiry. A lookup already in the cache is only replaced by a newer one.",
+	Example: "  geo cache import lookups.jsonl",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		r := mustOpenInput(args[0])
+		defer r.Close()
+
+		imported, err := mustOpenCache().Import(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import '%s': %s\n", args[0], err)
+			os.Exit(ExitError)
+		}
+		fmt.Printf("Imported %d entries.\n", imported)
+	},
+}
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Look up every query in a list ahead of time, one per line",
+	Long: "Look up every query in a list ahead of time, one per line.\n\n" +
+		"Queries already cached are skipped, unless '--refresh' is set. Blank lines and lines starting with '#' are ignored.",
+	Example: "  geo cache warm --input list.txt --parallel 4",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if noCache {
+			fmt.Fprintf(os.Stderr, "'geo cache warm' cannot be used with '--no-cache'.\n")
+			os.Exit(ExitUsage)
+		}
+
+		r := mustOpenInput(warmInput)
+		queries, err := readQueries(r)
+		_ = r.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read '%s': %s\n", warmInput, err)
+			os.Exit(ExitError)
+		}
+
+		c := mustOpenCache()
+		scope := resolveScope()
+		var uncached []string
+		for _, query := range queries {
+			if _, ok, err := c.Get(internalcmd.CacheKey(scope, query)); refreshCache || err != nil || !ok {
+				uncached = append(uncached, query)
+			}
+		}
+
+		resolve := c.Resolver(scope, newResolver(newGeocoder()), refreshCache)
+		s := newSummary()
+		warmed, misses := 0, 0
+		for i, result := range internalcmd.ResolveAll(cmd.Context(), resolve, uncached, parallel) {
+			switch {
+			case errors.Is(result.Err, internalcmd.ErrUnauthorized):
+				printUnauthorized()
+				os.Exit(ExitAuth)
+			case result.Err != nil:
+				fmt.Fprintf(os.Stderr, "unexpected error when getting the location '%s': %s\n", uncached[i], result.Err)
+				s.add(uncached[i], internalcmd.BatchError, result.Err)
+				continue
+			case len(result.Lookup.Records) == 0:
+				misses++
+			}
+			// A query that matched nothing is cached all the same, so it was warmed.
+			warmed++
+			s.add(uncached[i], internalcmd.BatchOK, nil)
+		}
+
+		fmt.Printf("Warmed %d queries (%d matched nothing), %d already cached.\n", warmed, misses, len(queries)-len(uncached))
+		if failures := s.counts[internalcmd.BatchError]; failures > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d queries failed.\n", failures, len(uncached))
+		}
+		if code := s.exitCode(); code != ExitOK {
+			os.Exit(code)
+		}
+	},
+}
+
+// withCache serves resolve through the lookup cache. Lookups go straight to the provider with
+// '--no-cache', or with a warning when the cache cannot be opened.
+func withCache(scope string, resolve internalcmd.Resolver) internalcmd.Resolver {
+	if noCache {
+		return resolve
+	}
+
+	if err := openCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: lookups will not be cached: %s\n", err)
+		return resolve
+	}
+
+	return cache.Resolver(scope, resolve, refreshCache)
+}
+
+// mustOpenCache opens the lookup cache for the 'geo cache' commands, or exits when it cannot be opened.
+func mustOpenCache() *internalcmd.Cache {
+	if err := openCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open the cache: %s\n", err)
+		os.Exit(ExitError)
+	}
+	return cache
+}
+
+func openCache() error {
+	if cache != nil {
+		return nil
+	}
+	path, err := internalcmd.DefaultCachePath()
+	if err != nil {
+		return err
+	}
+	cache, err = internalcmd.OpenCache(path, cacheTTL, cacheNegativeTTL)
+	return err
+}
+
+func closeCache() {
+	if cache != nil {
+		_ = cache.Close()
+	}
+}
+
+// mustOpenInput opens path for reading, '-' being standard input, or exits when it cannot be opened.
+func mustOpenInput(path string) io.ReadCloser {
+	if path == "-" {
+		return io.NopCloser(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open '%s': %s\n", path, err)
+		os.Exit(ExitError)
+	}
+	return f
+}
+
+// readQueries returns the query on each line of r, skipping blank lines and '#' comments.
+func readQueries(r io.Reader) ([]string, error) {
+	var queries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		query := strings.TrimSpace(scanner.Text())
+		if query == "" || strings.HasPrefix(query, "#") {
+			continue
+		}
+		queries = append(queries, query)
+	}
+	return queries, scanner.Err()
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatAge rounds d to whole seconds, or to hours when it is longer than a day.
+func formatAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return d.Round(time.Second).String()
+	}
+	days := d / (24 * time.Hour)
+	return fmt.Sprintf("%dd%dh", days, (d-days*24*time.Hour)/time.Hour)
+}
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "neither read nor write the lookup cache")
+	RootCmd.PersistentFlags().BoolVar(&refreshCache, "refresh", false, "ignore cached lookups, replacing them with fresh ones from the provider")
+	RootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", 30*24*time.Hour, "how long a lookup that matched is reused from the cache")
+	RootCmd.PersistentFlags().DurationVar(&cacheNegativeTTL, "cache-negative-ttl", 24*time.Hour, "how long a lookup that matched nothing is reused from the cache")
+
+	cachePurgeCmd.Flags().DurationVar(&purgeOlderThan, "older-than", 0, "remove lookups stored longer ago than this, such as 168h")
+	cachePurgeCmd.Flags().StringVar(&purgeQuery, "query", "", "remove lookups of this query, ignoring case and spacing")
+	cachePurgeCmd.Flags().BoolVar(&purgeAll, "all", false, "remove every lookup")
+	cacheWarmCmd.Flags().StringVar(&warmInput, "input", "-", "file of queries, one per line, '-' reads standard input")
+
+	CacheCmd.AddCommand(cacheStatsCmd)
+	CacheCmd.AddCommand(cachePurgeCmd)
+	CacheCmd.AddCommand(cacheExportCmd)
+	CacheCmd.AddCommand(cacheImportCmd)
+	CacheCmd.AddCommand(cacheWarmCmd)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"os"
+	"path"
+	"strings"
+)
+
+var DbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Build the offline GeoNames index of '--provider offline'",
+	Long: "Build the offline GeoNames index of '--provider offline'.\n\n" +
+		"The index is kept in $XDG_DATA_HOME/geo/" + internalcmd.GeoNamesFileName + ", or ~/.local/share/geo when " +
+		"XDG_DATA_HOME is not set. Pick another file with '--db' or '" + GeoDbName + "'.",
+}
+
+var dbImportCmd = &cobra.Command{
+	Use:   "import <file>...",
+	Short: "Import GeoNames dumps into the offline index",
+	Long: "Import GeoNames dumps into the offline index, creating it when needed.\n\n" +
+		"Dumps of populated places, such as allCountries.txt or cities500.txt of https://download.geonames.org/export/dump/, " +
+		"of postal codes, such as allCountries.txt of https://download.geonames.org/export/zip/, and of region names, " +
+		"admin1CodesASCII.txt, are told apart by their columns. Zip archives are read as downloaded, '-' reads standard input.",
+	Example: "  geo db import cities500.zip admin1CodesASCII.txt\n  geo db import zip/allCountries.zip",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := internalcmd.OpenGeoNames(dbPath, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the offline index: %s\n", err)
+			os.Exit(ExitError)
+		}
+		defer index.Close()
+
+		for _, file := range args {
+			counts, err := importDump(index, file)
+			if err != nil {
+				index.Close()
+				fmt.Fprintf(os.Stderr, "failed to import '%s': %s\n", file, err)
+				os.Exit(ExitError)
+			}
+			fmt.Printf("Imported '%s': %d places, %d postal codes, %d regions.\n", file, counts.Places, counts.PostalCodes, counts.Regions)
+		}
+	},
+}
+
+// importDump imports a GeoNames dump, or every dump in a zip archive of them.
+func importDump(index *internalcmd.GeoNamesIndex, file string) (internalcmd.GeoNamesImport, error) {
+	if !strings.EqualFold(path.Ext(file), ".zip") {
+		r := mustOpenInput(file)
+		defer r.Close()
+		return index.Import(r)
+	}
+
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return internalcmd.GeoNamesImport{}, err
+	}
+	defer archive.Close()
+
+	var total internalcmd.GeoNamesImport
+	for _, f := range archive.File {
+		if !strings.EqualFold(path.Ext(f.Name), ".txt") || strings.EqualFold(path.Base(f.Name), "readme.txt") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return total, err
+		}
+		counts, err := index.Import(r)
+		_ = r.Close()
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		total.Places += counts.Places
+		total.PostalCodes += counts.PostalCodes
+		total.Regions += counts.Regions
+	}
+	if total == (internalcmd.GeoNamesImport{}) {
+		return total, errors.New("no GeoNames dump found in the archive")
+	}
+	return total, nil
+}
+
+func init() {
+	DbCmd.AddCommand(dbImportCmd)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+)
+
+// Exit codes are part of the interface of geo, so scripts can react to the kind of failure.
+// Add new codes rather than changing the meaning of these.
+const (
+	ExitOK       = 0
+	ExitError    = 1 // anything not covered below, such as failing to read input or write output
+	ExitUsage    = 2 // invalid arguments or flags
+	ExitAuth     = 3 // the API key is missing or invalid, or the provider refused the request
+	ExitNotFound = 4 // a query matched nothing
+	ExitUpstream = 5 // the provider failed, refused to answer or could not be reached
+	ExitPartial  = 6 // some, but not all, queries of a run failed or matched nothing
+)
+
+// exitCode returns the exit code for a failed lookup.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, internalcmd.ErrUnauthorized):
+		return ExitAuth
+	case errors.Is(err, internalcmd.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, context.Canceled):
+		return ExitError // interrupted, which says nothing about the provider
+	}
+	return ExitUpstream
+}
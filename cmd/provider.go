@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// providers builds the geocoder of each name accepted by '--provider'.
+var providers = map[string]func() internalcmd.Geocoder{
+	"census":      newCensus,
+	"nominatim":   newNominatim,
+	"offline":     newOffline,
+	"openweather": newOpenWeather,
+}
+
+// providerColumns are the extra CSV columns of the fields a provider reports beyond those of OpenWeather.
+var providerColumns = map[string][]string{
+	"census":    internalcmd.CensusColumns,
+	"nominatim": internalcmd.OSMColumns,
+}
+
+func providerNames() []string {
+	return slices.Sorted(maps.Keys(providers))
+}
+
+// newGeocoder builds the geocoder of '--provider', which PersistentPreRun has already checked, shared by every command.
+func newGeocoder() internalcmd.Geocoder {
+	return providers[provider]()
+}
+
+// newResolver resolves free-form queries with g, reading postal codes without a country as those of '--country'.
+func newResolver(g internalcmd.Geocoder) internalcmd.Resolver {
+	return internalcmd.NewResolver(g, mustDefaultCountry())
+}
+
+// newOpenWeather builds the OpenWeather client from the environment and global flags.
+func newOpenWeather() internalcmd.Geocoder {
+	g := &internalcmd.DirectGeocoding{
+		Key:     mustApiKey(),
+		BaseURL: apiUrl,
+		Country: mustDefaultCountry(),
+		Limit:   nameLimit,
+		Lang:    strings.ToLower(lang),
+		Client:  &http.Client{Timeout: timeout},
+		Retry:   &retry,
+	}
+	if rateLimit > 0 {
+		g.Limiter = internalcmd.NewRateLimiter(rateLimit, rateBurst)
+	}
+	return g
+}
+
+// censusBatchTimeout bounds each batch upload of '--provider census', which can take minutes for the
+// largest batches, rather than '--timeout'.
+const censusBatchTimeout = 30 * time.Minute
+
+// newCensus builds the Census geocoder client from the global flags.
+func newCensus() internalcmd.Geocoder {
+	c := census
+	c.Client = &http.Client{Timeout: timeout}
+	c.BatchClient = &http.Client{Timeout: censusBatchTimeout}
+	c.Retry = &retry
+	if rateLimit > 0 {
+		c.Limiter = internalcmd.NewRateLimiter(rateLimit, rateBurst)
+	}
+	return &c
+}
+
+// newNominatim builds the Nominatim client from the global flags. The public server is paced to one request
+// a second whatever '--rate-limit' says.
+func newNominatim() internalcmd.Geocoder {
+	n := nominatim
+	n.Country = mustDefaultCountry()
+	n.Limit = nameLimit
+	n.Lang = strings.ToLower(lang)
+	n.Client = &http.Client{Timeout: timeout}
+	n.Retry = &retry
+	if rateLimit > 0 {
+		n.Limiter = internalcmd.NewRateLimiter(rateLimit, rateBurst)
+	}
+	return &n
+}
+
+// offlineIndex is opened by newOffline, closed by Execute.
+var offlineIndex *internalcmd.GeoNamesIndex
+
+// newOffline opens the GeoNames index of '--db' to answer lookups without a network, or exits when there is none.
+func newOffline() internalcmd.Geocoder {
+	index, err := internalcmd.OpenGeoNames(dbPath, true)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "no offline index at '%s', build one with 'geo db import <file>'.\n", dbPath)
+		os.Exit(ExitError)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open the offline index: %s\n", err)
+		os.Exit(ExitError)
+	}
+	offlineIndex = index
+	return &internalcmd.Offline{Index: index, Country: mustDefaultCountry(), Limit: nameLimit}
+}
+
+func closeOfflineIndex() {
+	if offlineIndex != nil {
+		_ = offlineIndex.Close()
+	}
+}
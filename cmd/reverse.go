@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var reverseLimit int
+
+var ReverseCmd = &cobra.Command{
+	Use:   "reverse <lat>,<lon>...",
+	Short: "Find the place names nearest to a set of coordinates",
+	Example: "  geo reverse 37.5385,-77.4343 \"47.6038, -122.3301\"\n" +
+		"  geo reverse -- -33.8688,151.2093",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Printf("No coordinates provided, please provide at least one '<lat>,<lon>' pair.\n\n")
+			_ = cmd.Usage()
+			os.Exit(ExitUsage)
+		}
+
+		if reverseLimit < 1 || reverseLimit > 5 {
+			fmt.Printf("'--limit' must be between 1 and 5, got %d.\n", reverseLimit)
+			os.Exit(ExitUsage)
+		}
+
+		if first && !cmd.Flags().Changed("limit") && state == "" {
+			reverseLimit = 1 // only the nearest place is printed, so only the nearest place is needed
+		}
+
+		for _, arg := range args {
+			if _, _, err := parseCoordinates(arg); err != nil {
+				fmt.Printf("%s\n", err)
+				os.Exit(ExitUsage)
+			}
+		}
+
+		out := mustFormat()
+		g := newGeocoder()
+
+		resolve := withFilters(withCache(fmt.Sprintf("%s:reverse:limit=%d%s", providerScope(), reverseLimit, langScope()), func(ctx context.Context, query string) (internalcmd.Lookup, error) {
+			lat, lon, _ := parseCoordinates(query) // already validated above
+			return internalcmd.ResolveCoordinates(ctx, g, query, lat, lon, reverseLimit)
+		}))
+
+		writeResults(out, internalcmd.ResolveAll(cmd.Context(), resolve, args, parallel))
+	},
+}
+
+// parseCoordinates reads a "<lat>,<lon>" pair, allowing whitespace around either value.
+func parseCoordinates(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("'%s' is not a '<lat>,<lon>' pair", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("'%s' is not a valid latitude, expected a number between -90 and 90", strings.TrimSpace(parts[0]))
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("'%s' is not a valid longitude, expected a number between -180 and 180", strings.TrimSpace(parts[1]))
+	}
+
+	return lat, lon, nil
+}
+
+func init() {
+	ReverseCmd.Flags().IntVar(&reverseLimit, "limit", 5, "maximum number of place names per coordinate pair (1-5)")
+	ReverseCmd.Flags().BoolVar(&first, "first", false, "print only the nearest place name of each coordinate pair")
+	ReverseCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "look up every coordinate pair even after one fails or matches nothing, then list the status of each")
+}
@@ -1,90 +1,351 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
 	internalcmd "github.com/squeedee/geo/internal/cmd"
-	"net/http"
-	"strconv"
+	"iter"
+	"net/url"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
 
 	"os"
 )
 
 const ApiKeyName = "OPEN_WEATHER_API_KEY"
+const ApiUrlName = "OPEN_WEATHER_API_URL"
+const DefaultCountryName = "GEO_DEFAULT_COUNTRY"
+const NominatimUrlName = "NOMINATIM_URL"
+const CensusUrlName = "CENSUS_GEOCODER_URL"
+const GeoDbName = "GEO_DB"
+
+// noCountry is the '--country' that leaves names and postal codes without a default country.
+const noCountry = "none"
+
+var (
+	apiUrl       string
+	census       internalcmd.Census
+	country      string
+	dbPath       string
+	first        bool
+	keepGoing    bool
+	lang         string
+	nameLimit    int
+	nominatim    internalcmd.Nominatim
+	outputFormat string
+	parallel     int
+	provider     string
+	rateBurst    int
+	rateLimit    int
+	relax        bool
+	retry        = internalcmd.DefaultRetryPolicy
+	state        string
+	timeout      time.Duration
+)
 
 var RootCmd = &cobra.Command{
 	Use:     "geo",
-	Short:   "Geo-locate place names and zip codes within the USA",
+	Args:    cobra.ArbitraryArgs, // place names, not subcommands
+	Short:   "Geo-locate place names and postal codes",
 	Example: "  geo \"Henrico, VA\" 10001 \"Seattle, WA\"",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if parallel < 1 {
+			fmt.Fprintf(os.Stderr, "'--parallel' must be at least 1, got %d.\n", parallel)
+			os.Exit(ExitUsage)
+		}
+		if retry.MaxAttempts < 1 || retry.BaseDelay < 0 || retry.MaxDelay < retry.BaseDelay {
+			fmt.Fprintf(os.Stderr, "'--retry-attempts' must be at least 1, and '--retry-max-delay' at least '--retry-base-delay'.\n")
+			os.Exit(ExitUsage)
+		}
+		for _, base := range []struct{ flag, value string }{{"api-url", apiUrl}, {"nominatim-url", nominatim.BaseURL}, {"census-url", census.BaseURL}} {
+			if !isHTTPURL(base.value) {
+				fmt.Fprintf(os.Stderr, "'--%s' must be an http or https URL such as 'https://example.com', got '%s'.\n", base.flag, base.value)
+				os.Exit(ExitUsage)
+			}
+		}
+		if _, ok := providers[provider]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown provider '%s', expected one of: %s\n", provider, strings.Join(providerNames(), ", "))
+			os.Exit(ExitUsage)
+		}
+		if _, ok := defaultCountry(); !ok {
+			fmt.Fprintf(os.Stderr, "'--country' must be an ISO 3166 alpha-2 or alpha-3 code such as 'CA' or 'CAN', or '%s', got '%s'.\n", noCountry, country)
+			os.Exit(ExitUsage)
+		}
+		if _, ok := internalcmd.USStateByName(state); state != "" && !ok {
+			fmt.Fprintf(os.Stderr, "'--state' must be a US state code or name such as 'VA' or 'Virginia', got '%s'.\n", state)
+			os.Exit(ExitUsage)
+		}
+		if lang != "" && !languagePattern.MatchString(lang) {
+			fmt.Fprintf(os.Stderr, "'--lang' must be an ISO 639-1 language code such as 'es' or 'fr-CA', got '%s'.\n", lang)
+			os.Exit(ExitUsage)
+		}
+		if rateLimit < 0 || rateBurst < 1 {
+			fmt.Fprintf(os.Stderr, "'--rate-limit' must be 0 or more and '--rate-burst' at least 1, got %d and %d.\n", rateLimit, rateBurst)
+			os.Exit(ExitUsage)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			fmt.Printf("No location arguments provided, please provide at least one location name, ZIP or Postal Code.\n\n")
 			_ = cmd.Usage()
-			os.Exit(1)
+			os.Exit(ExitUsage)
 		}
 
-		apiKey, apiKeyFound := os.LookupEnv(ApiKeyName)
-		if !apiKeyFound || apiKey == "" {
-			fmt.Printf("'%s' not set. Please visit 'https://openweathermap.org/api' and obtain an API key.", ApiKeyName)
-			fmt.Printf("Set the key before runing 'geo' with:\n\texport %s=<your openweather api key>", ApiKeyName)
-			os.Exit(1)
+		if cmd.Flags().Changed("limit") && (nameLimit < 1 || nameLimit > 5) {
+			fmt.Fprintf(os.Stderr, "'--limit' must be between 1 and 5, got %d.\n", nameLimit)
+			os.Exit(ExitUsage)
 		}
-
-		g := internalcmd.DirectGeocoding{
-			Key: apiKey,
+		if first && nameLimit == 0 && state == "" {
+			nameLimit = 1 // only the best match is printed, so only the best match is needed
 		}
 
-		for _, arg := range args {
-
-			fmt.Printf("'%s' results:\n", arg)
-
-			if _, conversionErr := strconv.Atoi(arg); conversionErr == nil { // numeric, use zip
-				loc, code, err := g.LocationByZip(arg)
-				if code == http.StatusUnauthorized {
-					fmt.Printf("'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.\n", ApiKeyName)
-					os.Exit(1)
-				}
-				if loc == nil {
-					fmt.Println("  No matches found.")
-					os.Exit(1)
-				}
-				if err != nil {
-					fmt.Printf("unexpected error when getting the location '%s': %s\n", arg, err)
-					os.Exit(1)
-				}
-				fmt.Printf("  Name: %s, %s, %s\n", loc.Name, loc.Country, loc.Zip)
-				fmt.Printf("  Lat,Lon: %f, %f\n\n", loc.Lat, loc.Lon)
-			} else { // non-numeric, use name
-				locations, code, err := g.LocationByName(arg)
-				if code == http.StatusUnauthorized {
-					fmt.Printf("'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.\n", ApiKeyName)
-					os.Exit(1)
-				}
-				if err != nil {
-					fmt.Printf("unexpected error when getting the location '%s': %s\n", arg, err)
-					os.Exit(1)
-				}
-				if len(locations) == 0 {
-					fmt.Printf("  No matches found.\n")
-					os.Exit(1)
-				}
-				for _, loc := range locations {
-					fmt.Printf("  Name: %s, %s, %s\n", loc.Name, loc.State, loc.Country)
-					fmt.Printf("  Lat,Lon: %f, %f\n\n", loc.Lat, loc.Lon)
-				}
-			}
+		out := mustFormat()
+		g := newGeocoder()
 
-		}
+		resolve := withRelax(withFilters(withCache(resolveScope(), newResolver(g))))
+		writeResults(out, internalcmd.ResolveAll(cmd.Context(), resolve, args, parallel))
 	},
 }
 
 func Execute() {
-	err := RootCmd.Execute()
+	// Interrupting geo cancels any lookup in flight rather than waiting on the API.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := RootCmd.ExecuteContext(ctx)
+	closeCache()
+	closeOfflineIndex()
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(ExitUsage) // cobra only fails on arguments and flags it cannot parse
+	}
+}
+
+// mustApiKey returns the OpenWeather API key from the environment, or exits with guidance when it is missing.
+func mustApiKey() string {
+	apiKey, apiKeyFound := os.LookupEnv(ApiKeyName)
+	if !apiKeyFound || apiKey == "" {
+		fmt.Printf("'%s' not set. Please visit 'https://openweathermap.org/api' and obtain an API key.", ApiKeyName)
+		fmt.Printf("Set the key before runing 'geo' with:\n\texport %s=<your openweather api key>", ApiKeyName)
+		os.Exit(ExitAuth)
+	}
+	return apiKey
+}
+
+// defaultCountry returns the ISO 3166 alpha-2 code of '--country', empty for 'none',
+// or false when it is neither an alpha-2 nor an alpha-3 code.
+func defaultCountry() (string, bool) {
+	if strings.EqualFold(country, noCountry) {
+		return "", true
+	}
+	c, ok := internalcmd.CountryByCode(country)
+	return c.Alpha2, ok
+}
+
+// isHTTPURL reports whether a provider's base URL is an absolute http or https URL.
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// languagePattern matches an ISO 639-1 language code, optionally followed by a region.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2}([-_][A-Za-z0-9]{2,8})?$`)
+
+// mustDefaultCountry returns defaultCountry, which PersistentPreRun has already checked.
+func mustDefaultCountry() string {
+	code, _ := defaultCountry()
+	return code
+}
+
+// withFilters narrows the places resolve finds to the '--state' given, if any, then to the best match with '--first'.
+// The filters apply after the cache, so cached lookups serve every state.
+func withFilters(resolve internalcmd.Resolver) internalcmd.Resolver {
+	code, filterState := internalcmd.USStateByName(state)
+	if !filterState && !first {
+		return resolve
+	}
+	return func(ctx context.Context, query string) (internalcmd.Lookup, error) {
+		lookup, err := resolve(ctx, query)
+		if filterState {
+			lookup = lookup.FilterState(code)
+		}
+		if first {
+			lookup = lookup.First()
+		}
+		return lookup, err
+	}
+}
+
+// withRelax retries a place name that resolve matched with nothing in simpler forms with '--relax', see internalcmd.Relax.
+// Each form is filtered and cached as a query of its own, so a relaxed match is never stored as the query's.
+func withRelax(resolve internalcmd.Resolver) internalcmd.Resolver {
+	if !relax {
+		return resolve
+	}
+	return internalcmd.Relax(resolve)
+}
+
+// mustFormat returns the Format selected with '--output', or exits when it is unknown.
+func mustFormat() internalcmd.Format {
+	out, err := internalcmd.NewFormat(outputFormat, os.Stdout, providerColumns[provider])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(ExitUsage)
+	}
+	return out
+}
+
+// writeResults writes every result and closes the output. It stops at the first query that failed
+// or matched nothing, unless '--keep-going' is set, which ends with the status of every query instead.
+func writeResults(out internalcmd.Format, results iter.Seq2[int, internalcmd.Result]) {
+	if !keepGoing {
+		for _, result := range results {
+			writeResult(out, result)
+		}
+		closeFormat(out)
+		return
+	}
+
+	s := newSummary()
+	for _, result := range results {
+		query := result.Lookup.Query
+		switch {
+		case errors.Is(result.Err, internalcmd.ErrUnauthorized):
+			exitInvalidApiKey(out) // every other query would fail the same way
+		case result.Err != nil:
+			fmt.Fprintf(os.Stderr, "unexpected error when getting the location '%s': %s\n", query, result.Err)
+			s.add(query, internalcmd.BatchError, result.Err)
+		case len(result.Lookup.Records) == 0:
+			writeLookup(out, result.Lookup)
+			s.add(query, internalcmd.BatchNoMatch, nil)
+		default:
+			writeLookup(out, result.Lookup)
+			s.add(query, internalcmd.BatchOK, nil)
+		}
+	}
+	closeFormat(out)
+
+	s.writeStatuses(os.Stderr)
+	s.writeTotals(os.Stderr)
+	os.Exit(s.exitCode())
+}
+
+// writeResult writes a successful lookup, or exits when the lookup failed or matched nothing.
+func writeResult(out internalcmd.Format, result internalcmd.Result) {
+	if errors.Is(result.Err, internalcmd.ErrUnauthorized) {
+		exitInvalidApiKey(out)
+	}
+	if result.Err != nil {
+		exitLookupError(out, result.Lookup.Query, result.Err)
+	}
+
+	writeLookup(out, result.Lookup)
+	if len(result.Lookup.Records) == 0 {
+		exitNoMatches(out, result.Lookup.Query)
+	}
+}
+
+func writeLookup(out internalcmd.Format, lookup internalcmd.Lookup) {
+	if err := out.WriteLookup(lookup); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write results for '%s': %s\n", lookup.Query, err)
+		os.Exit(ExitError)
+	}
+}
+
+func closeFormat(out internalcmd.Format) {
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write results: %s\n", err)
+		os.Exit(ExitError)
+	}
+}
+
+// printUnauthorized explains a request the provider refused. Only OpenWeather takes an API key, so when another
+// provider refuses a request, the cause is most likely the server or a proxy in front of it.
+func printUnauthorized() {
+	if provider != "openweather" {
+		fmt.Fprintf(os.Stderr, "the '%s' provider refused the request (401/403). Please check the server or proxy in front of it.\n", provider)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.\n", ApiKeyName)
+}
+
+// The exit helpers close the output first, so the results written so far remain a valid document.
+
+func exitInvalidApiKey(out internalcmd.Format) {
+	closeFormat(out)
+	printUnauthorized()
+	os.Exit(ExitAuth)
+}
+
+func exitLookupError(out internalcmd.Format, query string, err error) {
+	closeFormat(out)
+	fmt.Fprintf(os.Stderr, "unexpected error when getting the location '%s': %s\n", query, err)
+	os.Exit(exitCode(err))
+}
+
+// exitNoMatches ends the run after a query matched nothing. The text format has already said so.
+func exitNoMatches(out internalcmd.Format, query string) {
+	closeFormat(out)
+	if outputFormat != "text" {
+		fmt.Fprintf(os.Stderr, "No matches found for '%s'.\n", query)
 	}
+	os.Exit(ExitNotFound)
 }
 
 func init() {
+	defaultApiUrl := internalcmd.DefaultBaseURL
+	if envApiUrl := os.Getenv(ApiUrlName); envApiUrl != "" {
+		defaultApiUrl = envApiUrl
+	}
+	defaultCensusUrl := internalcmd.DefaultCensusURL
+	if envCensusUrl := os.Getenv(CensusUrlName); envCensusUrl != "" {
+		defaultCensusUrl = envCensusUrl
+	}
+	defaultNominatimUrl := internalcmd.DefaultNominatimURL
+	if envNominatimUrl := os.Getenv(NominatimUrlName); envNominatimUrl != "" {
+		defaultNominatimUrl = envNominatimUrl
+	}
+	defaultDbPath, _ := internalcmd.DefaultGeoNamesPath() // left empty without a home directory, '--db' is then required
+	if envDbPath := os.Getenv(GeoDbName); envDbPath != "" {
+		defaultDbPath = envDbPath
+	}
+	defaultCountryFlag := "US"
+	if envCountry := os.Getenv(DefaultCountryName); envCountry != "" {
+		defaultCountryFlag = envCountry
+	}
+
+	RootCmd.Flags().BoolVar(&first, "first", false, "print only the best match of each query, for scripts that need exactly one coordinate")
+	RootCmd.Flags().IntVar(&nameLimit, "limit", 0, "maximum number of matches per place name (1-5), left to the provider when unset")
+	RootCmd.Flags().BoolVar(&relax, "relax", false, "retry a place name that matches nothing without its street, county, then neighbourhood, reporting the form that matched")
+	RootCmd.Flags().BoolVar(&keepGoing, "keep-going", false, "look up every query even after one fails or matches nothing, then list the status of each")
+
+	RootCmd.PersistentFlags().StringVar(&apiUrl, "api-url", defaultApiUrl, fmt.Sprintf("OpenWeather API base URL, also set with '%s'", ApiUrlName))
+	RootCmd.PersistentFlags().StringVar(&census.BaseURL, "census-url", defaultCensusUrl, fmt.Sprintf("Census geocoder of '--provider census', also set with '%s'", CensusUrlName))
+	RootCmd.PersistentFlags().StringVar(&census.Benchmark, "census-benchmark", internalcmd.DefaultCensusBenchmark, "address ranges '--provider census' searches, such as 'Public_AR_Census2020'")
+	RootCmd.PersistentFlags().StringVar(&census.Vintage, "census-vintage", internalcmd.DefaultCensusVintage, "census geography '--provider census' reports tracts and blocks of, such as 'Census2020_Census2020'")
+	RootCmd.PersistentFlags().StringVar(&country, "country", defaultCountryFlag, fmt.Sprintf("ISO 3166 alpha-2 or alpha-3 country of place names and postal codes that do not name one, or '%s', also set with '%s'", noCountry, DefaultCountryName))
+	RootCmd.PersistentFlags().StringVar(&dbPath, "db", defaultDbPath, fmt.Sprintf("GeoNames index of '--provider offline' and 'geo db', also set with '%s'", GeoDbName))
+	RootCmd.PersistentFlags().StringVar(&lang, "lang", "", "ISO 639-1 language of place names, such as 'es', falling back to the provider's name when it has no translation")
+	RootCmd.PersistentFlags().StringVar(&nominatim.BaseURL, "nominatim-url", defaultNominatimUrl, fmt.Sprintf("Nominatim server of '--provider nominatim', also set with '%s'", NominatimUrlName))
+	RootCmd.PersistentFlags().StringVar(&nominatim.UserAgent, "nominatim-user-agent", internalcmd.DefaultNominatimUserAgent, "User-Agent sent to Nominatim, naming your application as the public server's usage policy asks")
+	RootCmd.PersistentFlags().StringVar(&nominatim.Email, "nominatim-email", "", "contact address sent to Nominatim, for the server operators to reach you about heavy use")
+	RootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", fmt.Sprintf("output format, one of: %s", strings.Join(internalcmd.Formats, ", ")))
+	RootCmd.PersistentFlags().IntVar(&parallel, "parallel", 1, "number of lookups to run at once, results keep their input order")
+	RootCmd.PersistentFlags().StringVar(&provider, "provider", "openweather", fmt.Sprintf("geocoding provider, one of: %s", strings.Join(providerNames(), ", ")))
+	RootCmd.PersistentFlags().IntVar(&rateLimit, "rate-limit", 60, "maximum API calls per minute, 0 for no limit")
+	RootCmd.PersistentFlags().IntVar(&rateBurst, "rate-burst", 10, "API calls allowed back to back before '--rate-limit' spaces them out")
+	RootCmd.PersistentFlags().IntVar(&retry.MaxAttempts, "retry-attempts", retry.MaxAttempts, "attempts per API call when the provider is rate limiting, failing or unreachable, 1 never retries")
+	RootCmd.PersistentFlags().DurationVar(&retry.BaseDelay, "retry-base-delay", retry.BaseDelay, "delay before the first retry, doubling for each retry after")
+	RootCmd.PersistentFlags().DurationVar(&retry.MaxDelay, "retry-max-delay", retry.MaxDelay, "longest delay between retries, including any 'Retry-After' from the provider")
+	RootCmd.PersistentFlags().StringVar(&state, "state", "", "only keep US places in this state, by code or name such as 'VA', postal codes are not filtered")
+	RootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 10*time.Second, "time limit for each API call, 0 for no limit")
+
+	RootCmd.AddCommand(BatchCmd)
+	RootCmd.AddCommand(CacheCmd)
+	RootCmd.AddCommand(DbCmd)
+	RootCmd.AddCommand(ReverseCmd)
 }
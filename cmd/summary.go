@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"io"
+	"strings"
+)
+
+// summary records the outcome of every query of a run that carries on past failures,
+// using the statuses of the 'geo_status' column written by 'geo batch'.
+type summary struct {
+	queries   []string
+	statuses  []internalcmd.BatchStatus
+	counts    map[internalcmd.BatchStatus]int
+	firstCode int // exit code of the first query that did not match
+}
+
+var summaryOrder = []internalcmd.BatchStatus{internalcmd.BatchOK, internalcmd.BatchNoMatch, internalcmd.BatchEmpty, internalcmd.BatchError}
+
+func newSummary() *summary {
+	return &summary{counts: map[internalcmd.BatchStatus]int{}}
+}
+
+// add records the status of a query, err being the failure of a lookup with BatchError.
+func (s *summary) add(query string, status internalcmd.BatchStatus, err error) {
+	s.queries = append(s.queries, query)
+	s.statuses = append(s.statuses, status)
+	s.counts[status]++
+	if s.firstCode != ExitOK {
+		return
+	}
+	switch status {
+	case internalcmd.BatchNoMatch:
+		s.firstCode = ExitNotFound
+	case internalcmd.BatchError:
+		s.firstCode = exitCode(err)
+	}
+}
+
+// writeStatuses writes the status of each query, one per line.
+func (s *summary) writeStatuses(w io.Writer) {
+	for i, query := range s.queries {
+		_, _ = fmt.Fprintf(w, "%-8s  %s\n", s.statuses[i], query)
+	}
+}
+
+// writeTotals writes how many queries ended with each status, such as "Processed 3 queries: 2 ok, 1 error."
+func (s *summary) writeTotals(w io.Writer) {
+	var totals []string
+	for _, status := range summaryOrder {
+		if n := s.counts[status]; n > 0 {
+			totals = append(totals, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	noun := "queries"
+	if len(s.queries) == 1 {
+		noun = "query"
+	}
+	if len(totals) == 0 {
+		_, _ = fmt.Fprintf(w, "Processed %d %s.\n", len(s.queries), noun)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Processed %d %s: %s.\n", len(s.queries), noun, strings.Join(totals, ", "))
+}
+
+// exitCode returns ExitOK when every query matched, ExitPartial when only some did, and
+// otherwise the code a run of the first query that did not match would have exited with.
+// Empty queries are skipped rather than looked up, so they count for neither.
+func (s *summary) exitCode() int {
+	switch {
+	case s.counts[internalcmd.BatchOK] == len(s.queries)-s.counts[internalcmd.BatchEmpty]:
+		return ExitOK
+	case s.counts[internalcmd.BatchOK] > 0:
+		return ExitPartial
+	}
+	return s.firstCode
+}
@@ -6,9 +6,11 @@ require (
 	github.com/MakeNowJust/heredoc v1.0.0
 	github.com/google/go-cmp v0.6.0
 	github.com/spf13/cobra v1.8.1
+	go.etcd.io/bbolt v1.3.11
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
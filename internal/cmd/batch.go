@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BatchStatus describes how a batch row was resolved.
+type BatchStatus string
+
+const (
+	BatchOK      BatchStatus = "ok"       // the query matched, the first match enriches the row
+	BatchNoMatch BatchStatus = "no_match" // the API did not recognise the query
+	BatchEmpty   BatchStatus = "empty"    // the query column was blank, so no lookup was made
+	BatchError   BatchStatus = "error"    // the lookup failed, see the error reported alongside
+)
+
+// BatchColumns are appended to the original columns of every batch row.
+// They are prefixed so they do not collide with columns already in the input.
+var BatchColumns = []string{"geo_lat", "geo_lon", "geo_name", "geo_state", "geo_country", "geo_status"}
+
+// BatchRelaxColumns follow BatchColumns when queries are relaxed, see Relax: the form of the query that matched,
+// and its precision, both empty for a query that matched as given.
+var BatchRelaxColumns = []string{"geo_relaxed", "geo_precision"}
+
+// BatchInput is a CSV document along with the column holding the queries.
+type BatchInput struct {
+	Header []string
+	Rows   [][]string
+	Column int // index of the query column in Header
+}
+
+// Query returns the query for the given row, or "" when the row is too short to have one.
+func (b *BatchInput) Query(row int) string {
+	if b.Column >= len(b.Rows[row]) {
+		return ""
+	}
+	return strings.TrimSpace(b.Rows[row][b.Column])
+}
+
+// ReadBatch reads a CSV document with a header row. The query column is matched by name,
+// ignoring case, and may be left empty when the document only has one column.
+func ReadBatch(r io.Reader, column string) (*BatchInput, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // spreadsheets export short rows, they are padded on write
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("input is empty, expected a header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // Excel starts UTF-8 exports with a byte order mark
+
+	input := &BatchInput{Header: header, Column: -1}
+	if column == "" {
+		if len(header) != 1 {
+			return nil, fmt.Errorf("input has %d columns, choose the query column from: %s", len(header), strings.Join(header, ", "))
+		}
+		input.Column = 0
+	}
+	for i, name := range header {
+		if column != "" && strings.EqualFold(strings.TrimSpace(name), column) {
+			input.Column = i
+			break
+		}
+	}
+	if input.Column < 0 {
+		return nil, fmt.Errorf("column '%s' not found, expected one of: %s", column, strings.Join(header, ", "))
+	}
+
+	input.Rows, err = reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range input.Rows {
+		if len(header) == 1 && len(row) > 1 {
+			// A single column file is usually a plain list, where the commas belong to the query.
+			input.Rows[i] = []string{strings.Join(row, ",")}
+			continue
+		}
+		if len(row) > len(header) {
+			return nil, fmt.Errorf("row %d has %d fields, but the header only has %d", i+1, len(row), len(header))
+		}
+	}
+	return input, nil
+}
+
+// BatchWriter writes the original batch rows, enriched with BatchColumns.
+type BatchWriter struct {
+	w       *csv.Writer
+	width   int
+	relaxed bool
+	extra   []string
+}
+
+// NewBatchWriter writes the header of the enriched document, with BatchRelaxColumns when relaxed is set, followed
+// by the extra columns, such as CensusColumns, prefixed like BatchColumns.
+func NewBatchWriter(w io.Writer, header []string, relaxed bool, extra []string) (*BatchWriter, error) {
+	b := &BatchWriter{w: csv.NewWriter(w), width: len(header), relaxed: relaxed, extra: extra}
+	columns := append(append([]string{}, header...), BatchColumns...)
+	if relaxed {
+		columns = append(columns, BatchRelaxColumns...)
+	}
+	for _, column := range extra {
+		columns = append(columns, "geo_"+column)
+	}
+	if err := b.w.Write(columns); err != nil {
+		return nil, err
+	}
+	b.w.Flush()
+	return b, b.w.Error()
+}
+
+// Write enriches a row with the first record of its lookup, flushing it so progress is visible.
+func (b *BatchWriter) Write(row []string, status BatchStatus, lookup Lookup) error {
+	enriched := make([]string, b.width, b.width+len(BatchColumns)+len(BatchRelaxColumns)+len(b.extra))
+	copy(enriched, row)
+
+	var r Record
+	if status == BatchOK && len(lookup.Records) > 0 {
+		r = lookup.Records[0]
+		enriched = append(enriched, formatCoordinate(r.Lat), formatCoordinate(r.Lon), r.Name, r.State, r.Country)
+	} else {
+		enriched = append(enriched, "", "", "", "", "")
+	}
+	enriched = append(enriched, string(status))
+	if b.relaxed {
+		enriched = append(enriched, lookup.Relaxed, string(lookup.Precision))
+	}
+	enriched = append(enriched, extraValues(r, b.extra)...)
+
+	if err := b.w.Write(enriched); err != nil {
+		return err
+	}
+	b.w.Flush()
+	return b.w.Error()
+}
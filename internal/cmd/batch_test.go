@@ -0,0 +1,190 @@
+package cmd_test
+
+import (
+	"bytes"
+	. "github.com/MakeNowJust/heredoc/dot"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"strings"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	tests := map[string]struct {
+		input         string
+		column        string
+		expectedError string
+		expected      *internalcmd.BatchInput
+	}{
+		"named column, matched ignoring case": {
+			input: D(`
+				id,Address
+				1,"Henrico, VA"
+				2,23228
+			`),
+			column: "address",
+			expected: &internalcmd.BatchInput{
+				Header: []string{"id", "Address"},
+				Rows:   [][]string{{"1", "Henrico, VA"}, {"2", "23228"}},
+				Column: 1,
+			},
+		},
+		"single column needs no name": {
+			input: "\ufeffplace\n\"Henrico, VA\"\n",
+			expected: &internalcmd.BatchInput{
+				Header: []string{"place"},
+				Rows:   [][]string{{"Henrico, VA"}},
+				Column: 0,
+			},
+		},
+		"short rows are kept": {
+			input:  "address,notes\n23228\n",
+			column: "address",
+			expected: &internalcmd.BatchInput{
+				Header: []string{"address", "notes"},
+				Rows:   [][]string{{"23228"}},
+				Column: 0,
+			},
+		},
+		"several columns need a name": {
+			input:         "id,address\n1,23228\n",
+			expectedError: "input has 2 columns, choose the query column from: id, address",
+		},
+		"unknown column": {
+			input:         "id,address\n1,23228\n",
+			column:        "zip",
+			expectedError: "column 'zip' not found, expected one of: id, address",
+		},
+		"single column rows keep their commas": {
+			input: "place\nHenrico, VA\nRichmond, VA, USA\n",
+			expected: &internalcmd.BatchInput{
+				Header: []string{"place"},
+				Rows:   [][]string{{"Henrico, VA"}, {"Richmond, VA, USA"}},
+				Column: 0,
+			},
+		},
+		"rows longer than the header": {
+			input:         "id,address\n1,23228,extra\n",
+			column:        "address",
+			expectedError: "row 1 has 3 fields, but the header only has 2",
+		},
+		"empty input": {
+			input:         "",
+			expectedError: "input is empty, expected a header row",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			input, err := internalcmd.ReadBatch(strings.NewReader(tc.input), tc.column)
+			if tc.expectedError == "" && err != nil {
+				t.Fatalf("ReadBatch() Unexpected error: %v", err)
+			} else if tc.expectedError != "" && err == nil {
+				t.Fatalf("ReadBatch() Expected error, but didn't get one")
+			} else if err != nil && tc.expectedError != err.Error() {
+				t.Fatalf("ReadBatch() Unexpected error: %s, expected %s", err, tc.expectedError)
+			}
+
+			if diff := cmp.Diff(tc.expected, input); diff != "" {
+				t.Errorf("ReadBatch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBatchWriter(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := internalcmd.NewBatchWriter(&buf, []string{"id", "address", "notes"}, false, nil)
+	if err != nil {
+		t.Fatalf("NewBatchWriter() Unexpected error: %v", err)
+	}
+
+	rows := []struct {
+		row    []string
+		status internalcmd.BatchStatus
+		lookup internalcmd.Lookup
+	}{
+		{[]string{"1", "Henrico, VA", "first"}, internalcmd.BatchOK, henricoLookup},
+		{[]string{"2", "23228"}, internalcmd.BatchOK, zipLookup},
+		{[]string{"3", "nowhere", ""}, internalcmd.BatchNoMatch, missedLookup},
+		{[]string{"4", "", "blank"}, internalcmd.BatchEmpty, internalcmd.Lookup{}},
+	}
+	for _, r := range rows {
+		if err := out.Write(r.row, r.status, r.lookup); err != nil {
+			t.Fatalf("Write() Unexpected error: %v", err)
+		}
+	}
+
+	expected := D(`
+		id,address,notes,geo_lat,geo_lon,geo_name,geo_state,geo_country,geo_status
+		1,"Henrico, VA",first,37.495702,-77.335257,Henrico,Virginia,US,ok
+		2,23228,,37.4638,-77.398,Henrico County,,US,ok
+		3,nowhere,,,,,,,no_match
+		4,,blank,,,,,,empty
+	`)
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("BatchWriter output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBatchWriter_Relaxed(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := internalcmd.NewBatchWriter(&buf, []string{"address"}, true, nil)
+	if err != nil {
+		t.Fatalf("NewBatchWriter() Unexpected error: %v", err)
+	}
+
+	for _, r := range []struct {
+		row    []string
+		status internalcmd.BatchStatus
+		lookup internalcmd.Lookup
+	}{
+		{[]string{"Henrico, VA"}, internalcmd.BatchOK, henricoLookup},
+		{[]string{"Short Pump, Henrico County, VA"}, internalcmd.BatchOK, relaxedLookup},
+		{[]string{"nowhere"}, internalcmd.BatchNoMatch, missedLookup},
+	} {
+		if err := out.Write(r.row, r.status, r.lookup); err != nil {
+			t.Fatalf("Write() Unexpected error: %v", err)
+		}
+	}
+
+	expected := D(`
+		address,geo_lat,geo_lon,geo_name,geo_state,geo_country,geo_status,geo_relaxed,geo_precision
+		"Henrico, VA",37.495702,-77.335257,Henrico,Virginia,US,ok,,
+		"Short Pump, Henrico County, VA",37.495702,-77.335257,Henrico,Virginia,US,ok,"Henrico, VA",city
+		nowhere,,,,,,no_match,,
+	`)
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("BatchWriter output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBatchWriter_Extra(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := internalcmd.NewBatchWriter(&buf, []string{"address"}, false, internalcmd.CensusColumns)
+	if err != nil {
+		t.Fatalf("NewBatchWriter() Unexpected error: %v", err)
+	}
+
+	for _, r := range []struct {
+		row    []string
+		status internalcmd.BatchStatus
+		lookup internalcmd.Lookup
+	}{
+		{[]string{"4600 Silver Hill Rd, Washington, DC 20233"}, internalcmd.BatchOK, censusLookup},
+		{[]string{"nowhere"}, internalcmd.BatchNoMatch, missedLookup},
+	} {
+		if err := out.Write(r.row, r.status, r.lookup); err != nil {
+			t.Fatalf("Write() Unexpected error: %v", err)
+		}
+	}
+
+	expected := D(`
+		address,geo_lat,geo_lon,geo_name,geo_state,geo_country,geo_status,geo_tract_fips,geo_block_fips
+		"4600 Silver Hill Rd, Washington, DC 20233",38.846016,-76.927487,"4600 SILVER HILL RD, WASHINGTON, DC, 20233",DC,US,ok,11001007601,110010076011013
+		nowhere,,,,,,no_match,,
+	`)
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("BatchWriter output mismatch (-want +got):\n%s", diff)
+	}
+}
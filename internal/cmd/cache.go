@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheFileName is the database file kept in the cache directory.
+const CacheFileName = "cache.db"
+
+var lookupsBucket = []byte("lookups")
+
+// Cache keeps lookups in a single bbolt database file, so repeat queries cost no API calls.
+// Lookups that matched something and lookups that matched nothing expire separately,
+// as a place that is missing today may be added to OpenWeather tomorrow.
+type Cache struct {
+	TTL         time.Duration // How long a lookup with matches is reused
+	NegativeTTL time.Duration // How long a lookup without matches is reused
+
+	db *bbolt.DB
+}
+
+// CacheEntry is a stored lookup, along with when it was stored and when it expires.
+type CacheEntry struct {
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Lookup    Lookup    `json:"lookup"`
+}
+
+// DefaultCachePath returns the cache database under $XDG_CACHE_HOME/geo, falling back
+// to the platform's user cache directory when XDG_CACHE_HOME is not set.
+func DefaultCachePath() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserCacheDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "geo", CacheFileName), nil
+}
+
+// OpenCache opens, or creates, the cache database at path. Only one process can hold the
+// database at a time, so opening fails if another does not let go within a second.
+func OpenCache(path string, ttl, negativeTTL time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("cache '%s' is in use by another process", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(lookupsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Cache{TTL: ttl, NegativeTTL: negativeTTL, db: db}, nil
+}
+
+// Close releases the database file.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Get returns the stored lookup for key, if there is one that has not expired.
+func (c *Cache) Get(key string) (Lookup, bool, error) {
+	var entry *CacheEntry
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(lookupsBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		entry = &CacheEntry{}
+		return json.Unmarshal(value, entry)
+	})
+	if err != nil || entry == nil || time.Now().After(entry.ExpiresAt) {
+		return Lookup{}, false, err
+	}
+	return entry.Lookup, true, nil
+}
+
+// Put stores a lookup under key, expiring after TTL, or NegativeTTL when it has no records.
+func (c *Cache) Put(key string, lookup Lookup) error {
+	ttl := c.TTL
+	if len(lookup.Records) == 0 {
+		ttl = c.NegativeTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	value, err := json.Marshal(CacheEntry{StoredAt: now, ExpiresAt: now.Add(ttl), Lookup: lookup})
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(lookupsBucket).Put([]byte(key), value)
+	})
+}
+
+// CacheKey identifies a query within a scope, the scope holding anything besides the query
+// that changes the result, such as the lookup kind or a result limit. Queries differing only in
+// case or spacing share a key.
+func CacheKey(scope, query string) string {
+	return scope + "\x00" + normalizeQuery(query)
+}
+
+// splitCacheKey returns the scope and normalized query of a key.
+func splitCacheKey(key string) (string, string) {
+	scope, query, _ := strings.Cut(key, "\x00")
+	return scope, query
+}
+
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// Resolver serves resolve's lookups from the cache, storing each new successful one.
+// With refresh set, stored lookups are ignored but still replaced. An unreadable entry
+// is treated as missing, so a damaged cache costs API calls rather than failing lookups.
+func (c *Cache) Resolver(scope string, resolve Resolver, refresh bool) Resolver {
+	return func(ctx context.Context, query string) (Lookup, error) {
+		key := CacheKey(scope, query)
+		if !refresh {
+			if lookup, ok, err := c.Get(key); err == nil && ok {
+				return lookup.withQuery(query), nil
+			}
+		}
+
+		lookup, err := resolve(ctx, query)
+		if err == nil {
+			_ = c.Put(key, lookup) // the cache is an optimisation, failing to fill it does not fail the lookup
+		}
+		return lookup, err
+	}
+}
+
+// withQuery returns a copy of the lookup attributed to query, so a cached lookup reports
+// the query as it was asked this time rather than when it was stored.
+func (l Lookup) withQuery(query string) Lookup {
+	l.Query = query
+	if l.Records == nil {
+		return l
+	}
+	records := make([]Record, len(l.Records))
+	for i, r := range l.Records {
+		r.Query = query
+		records[i] = r
+	}
+	l.Records = records
+	return l
+}
+
+// CacheStats summarises the contents of a cache.
+type CacheStats struct {
+	Path    string
+	Size    int64 // Bytes used by the database file
+	Entries int
+	Matches int // Entries whose lookup matched at least one place
+	Misses  int // Entries whose lookup matched nothing
+	Expired int // Entries past their TTL, which are replaced on their next lookup
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// Stats counts the entries in the cache.
+func (c *Cache) Stats() (CacheStats, error) {
+	stats := CacheStats{Path: c.db.Path()}
+	now := time.Now()
+
+	err := c.forEach(func(key string, entry CacheEntry) error {
+		stats.Entries++
+		if len(entry.Lookup.Records) > 0 {
+			stats.Matches++
+		} else {
+			stats.Misses++
+		}
+		if now.After(entry.ExpiresAt) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || entry.StoredAt.Before(stats.Oldest) {
+			stats.Oldest = entry.StoredAt
+		}
+		if entry.StoredAt.After(stats.Newest) {
+			stats.Newest = entry.StoredAt
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	info, err := os.Stat(c.db.Path())
+	if err != nil {
+		return stats, err
+	}
+	stats.Size = info.Size()
+	return stats, nil
+}
+
+// Purge removes the entries stored more than olderThan ago, and returns how many were removed.
+// A non-empty query narrows the purge to that query, in every scope. With neither set, every entry is removed.
+func (c *Cache) Purge(olderThan time.Duration, query string) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	query = normalizeQuery(query)
+
+	purged := 0
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(lookupsBucket).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if _, entryQuery := splitCacheKey(string(key)); query != "" && entryQuery != query {
+				continue
+			}
+
+			if olderThan > 0 {
+				var entry CacheEntry
+				if err := json.Unmarshal(value, &entry); err == nil && entry.StoredAt.After(cutoff) {
+					continue // unreadable entries are always purged
+				}
+			}
+
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// cacheLine is a single entry in the JSON lines written by Export and read by Import.
+type cacheLine struct {
+	Scope string `json:"scope"`
+	Query string `json:"query"`
+	CacheEntry
+}
+
+// Export writes every entry as a line of JSON, for moving lookups to another machine with Import.
+// It returns how many entries were written.
+func (c *Cache) Export(w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	exported := 0
+	err := c.forEach(func(key string, entry CacheEntry) error {
+		scope, query := splitCacheKey(key)
+		exported++
+		return enc.Encode(cacheLine{Scope: scope, Query: query, CacheEntry: entry})
+	})
+	return exported, err
+}
+
+// Import reads the JSON lines written by Export, keeping their original timestamps.
+// An entry only replaces one already in the cache when it was stored more recently.
+// It returns how many entries were added or replaced.
+func (c *Cache) Import(r io.Reader) (int, error) {
+	imported := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(lookupsBucket)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+
+			var entry cacheLine
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if entry.Scope == "" || entry.Query == "" || entry.ExpiresAt.IsZero() {
+				return fmt.Errorf("line %d: expected 'scope', 'query' and 'expires_at'", line)
+			}
+
+			key := []byte(CacheKey(entry.Scope, entry.Query))
+			if existing := bucket.Get(key); existing != nil {
+				var current CacheEntry
+				if err := json.Unmarshal(existing, &current); err == nil && !entry.StoredAt.After(current.StoredAt) {
+					continue
+				}
+			}
+
+			value, err := json.Marshal(entry.CacheEntry)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(key, value); err != nil {
+				return err
+			}
+			imported++
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// forEach calls fn with every readable entry, skipping any that cannot be decoded.
+func (c *Cache) forEach(fn func(key string, entry CacheEntry) error) error {
+	return c.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(lookupsBucket).ForEach(func(key, value []byte) error {
+			var entry CacheEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return nil
+			}
+			return fn(string(key), entry)
+		})
+	})
+}
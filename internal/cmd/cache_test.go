@@ -0,0 +1,293 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingResolver answers from a fixed set of lookups, counting the calls that reach it.
+type countingResolver struct {
+	lookups map[string]internalcmd.Lookup
+	calls   int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, query string) (internalcmd.Lookup, error) {
+	r.calls++
+	if query == "fail" {
+		return internalcmd.Lookup{Query: query}, &internalcmd.APIError{StatusCode: http.StatusBadGateway, Err: internalcmd.ErrUpstream}
+	}
+	lookup, ok := r.lookups[query]
+	if !ok {
+		return internalcmd.Lookup{Query: query, Type: internalcmd.LookupName}, nil
+	}
+	return lookup, nil
+}
+
+func openTestCache(t *testing.T, ttl, negativeTTL time.Duration) *internalcmd.Cache {
+	cache, err := internalcmd.OpenCache(filepath.Join(t.TempDir(), "geo", internalcmd.CacheFileName), ttl, negativeTTL)
+	if err != nil {
+		t.Fatalf("OpenCache() Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache
+}
+
+func TestCache_Resolver(t *testing.T) {
+	cache := openTestCache(t, time.Hour, time.Hour)
+	upstream := &countingResolver{lookups: map[string]internalcmd.Lookup{"Henrico, VA": henricoLookup}}
+	resolve := cache.Resolver("test", upstream.Resolve, false)
+
+	for _, query := range []string{"Henrico, VA", "Henrico, VA", "henrico,   va"} {
+		lookup, err := resolve(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Resolver() Unexpected error: %v", err)
+		}
+
+		expected := internalcmd.NewNameLookup(query, internalcmd.LookupName, []internalcmd.NameResult{
+			{Name: "Henrico", Lat: 37.495702, Lon: -77.335257, Country: "US", State: "Virginia"},
+		})
+		if diff := cmp.Diff(expected, lookup); diff != "" {
+			t.Errorf("Resolver() mismatch for '%s' (-want +got):\n%s", query, diff)
+		}
+	}
+
+	if upstream.calls != 1 {
+		t.Errorf("Resolver() made %d upstream calls, expected 1", upstream.calls)
+	}
+}
+
+func TestCache_ResolverRefresh(t *testing.T) {
+	cache := openTestCache(t, time.Hour, time.Hour)
+	upstream := &countingResolver{lookups: map[string]internalcmd.Lookup{"Henrico, VA": henricoLookup}}
+
+	_, _ = cache.Resolver("test", upstream.Resolve, false)(context.Background(), "Henrico, VA")
+	_, _ = cache.Resolver("test", upstream.Resolve, true)(context.Background(), "Henrico, VA")
+	_, _ = cache.Resolver("test", upstream.Resolve, false)(context.Background(), "Henrico, VA")
+
+	if upstream.calls != 2 {
+		t.Errorf("Resolver() made %d upstream calls, expected the refresh to add exactly 1", upstream.calls)
+	}
+}
+
+func TestCache_ResolverScopes(t *testing.T) {
+	cache := openTestCache(t, time.Hour, time.Hour)
+	upstream := &countingResolver{lookups: map[string]internalcmd.Lookup{"Henrico, VA": henricoLookup}}
+
+	_, _ = cache.Resolver("reverse:limit=1", upstream.Resolve, false)(context.Background(), "Henrico, VA")
+	_, _ = cache.Resolver("reverse:limit=5", upstream.Resolve, false)(context.Background(), "Henrico, VA")
+
+	if upstream.calls != 2 {
+		t.Errorf("Resolver() made %d upstream calls, expected scopes not to share entries", upstream.calls)
+	}
+}
+
+func TestCache_ResolverExpiry(t *testing.T) {
+	tests := map[string]struct {
+		ttl, negativeTTL time.Duration
+		query            string
+		expectCalls      int
+	}{
+		"matches are reused within their ttl": {
+			ttl: time.Hour, negativeTTL: time.Nanosecond, query: "Henrico, VA", expectCalls: 1,
+		},
+		"matches expire after their ttl": {
+			ttl: time.Nanosecond, negativeTTL: time.Hour, query: "Henrico, VA", expectCalls: 2,
+		},
+		"misses are reused within the negative ttl": {
+			ttl: time.Nanosecond, negativeTTL: time.Hour, query: "nowhere", expectCalls: 1,
+		},
+		"misses expire after the negative ttl": {
+			ttl: time.Hour, negativeTTL: time.Nanosecond, query: "nowhere", expectCalls: 2,
+		},
+		"a zero negative ttl never stores misses": {
+			ttl: time.Hour, negativeTTL: 0, query: "nowhere", expectCalls: 2,
+		},
+		"failures are never stored": {
+			ttl: time.Hour, negativeTTL: time.Hour, query: "fail", expectCalls: 2,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cache := openTestCache(t, tc.ttl, tc.negativeTTL)
+			upstream := &countingResolver{lookups: map[string]internalcmd.Lookup{"Henrico, VA": henricoLookup}}
+			resolve := cache.Resolver("test", upstream.Resolve, false)
+
+			_, _ = resolve(context.Background(), tc.query)
+			time.Sleep(time.Millisecond)
+			_, _ = resolve(context.Background(), tc.query)
+
+			if upstream.calls != tc.expectCalls {
+				t.Errorf("Resolver() made %d upstream calls, expected %d", upstream.calls, tc.expectCalls)
+			}
+		})
+	}
+}
+
+func TestCache_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), internalcmd.CacheFileName)
+
+	cache, err := internalcmd.OpenCache(path, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenCache() Unexpected error: %v", err)
+	}
+	if err = cache.Put(internalcmd.CacheKey("test", "23228"), zipLookup); err != nil {
+		t.Fatalf("Put() Unexpected error: %v", err)
+	}
+	if err = cache.Close(); err != nil {
+		t.Fatalf("Close() Unexpected error: %v", err)
+	}
+
+	cache, err = internalcmd.OpenCache(path, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenCache() Unexpected error: %v", err)
+	}
+	defer cache.Close()
+
+	lookup, ok, err := cache.Get(internalcmd.CacheKey("test", "23228"))
+	if err != nil || !ok {
+		t.Fatalf("Get() Expected the stored lookup, got ok=%t, err=%v", ok, err)
+	}
+	if diff := cmp.Diff(zipLookup, lookup); diff != "" {
+		t.Errorf("Get() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCache_Stats(t *testing.T) {
+	cache := openTestCache(t, time.Hour, time.Nanosecond)
+	_ = cache.Put(internalcmd.CacheKey("test", "Henrico, VA"), henricoLookup)
+	_ = cache.Put(internalcmd.CacheKey("test", "23228"), zipLookup)
+	_ = cache.Put(internalcmd.CacheKey("test", "nowhere"), missedLookup)
+	time.Sleep(time.Millisecond)
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Stats() Unexpected error: %v", err)
+	}
+
+	if stats.Entries != 3 || stats.Matches != 2 || stats.Misses != 1 || stats.Expired != 1 {
+		t.Errorf("Stats() counted %d entries, %d matches, %d misses, %d expired, expected 3, 2, 1, 1",
+			stats.Entries, stats.Matches, stats.Misses, stats.Expired)
+	}
+	if stats.Size <= 0 {
+		t.Errorf("Stats() Size = %d, expected the size of the database file", stats.Size)
+	}
+	if stats.Oldest.IsZero() || stats.Newest.Before(stats.Oldest) {
+		t.Errorf("Stats() Oldest = %s, Newest = %s, expected Oldest to be set and not after Newest", stats.Oldest, stats.Newest)
+	}
+}
+
+func TestCache_Purge(t *testing.T) {
+	tests := map[string]struct {
+		olderThan   time.Duration
+		query       string
+		expectCount int
+		expectKept  []string
+	}{
+		"everything": {
+			expectCount: 3,
+		},
+		"a query in every scope, ignoring case and spacing": {
+			query:       "henrico,   VA",
+			expectCount: 2,
+			expectKept:  []string{"23228"},
+		},
+		"entries older than the cutoff": {
+			olderThan:   time.Millisecond,
+			expectCount: 3,
+		},
+		"entries newer than the cutoff are kept": {
+			olderThan:   time.Hour,
+			expectCount: 0,
+			expectKept:  []string{"Henrico, VA", "23228"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cache := openTestCache(t, time.Hour, time.Hour)
+			_ = cache.Put(internalcmd.CacheKey("name", "Henrico, VA"), henricoLookup)
+			_ = cache.Put(internalcmd.CacheKey("other", "Henrico, VA"), henricoLookup)
+			_ = cache.Put(internalcmd.CacheKey("name", "23228"), zipLookup)
+			time.Sleep(2 * time.Millisecond)
+
+			purged, err := cache.Purge(tc.olderThan, tc.query)
+			if err != nil {
+				t.Fatalf("Purge() Unexpected error: %v", err)
+			}
+			if purged != tc.expectCount {
+				t.Errorf("Purge() removed %d entries, expected %d", purged, tc.expectCount)
+			}
+
+			for _, query := range tc.expectKept {
+				if _, ok, _ := cache.Get(internalcmd.CacheKey("name", query)); !ok {
+					t.Errorf("Purge() removed '%s', expected it to be kept", query)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	source := openTestCache(t, time.Hour, time.Hour)
+	_ = source.Put(internalcmd.CacheKey("name", "Henrico, VA"), henricoLookup)
+	_ = source.Put(internalcmd.CacheKey("name", "23228"), zipLookup)
+
+	var exported bytes.Buffer
+	count, err := source.Export(&exported)
+	if err != nil {
+		t.Fatalf("Export() Unexpected error: %v", err)
+	}
+	if lines := strings.Count(exported.String(), "\n"); count != 2 || lines != 2 {
+		t.Fatalf("Export() wrote %d entries on %d lines, expected 2", count, lines)
+	}
+
+	target := openTestCache(t, time.Hour, time.Hour)
+	imported, err := target.Import(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatalf("Import() Unexpected error: %v", err)
+	}
+	if imported != 2 {
+		t.Errorf("Import() added %d entries, expected 2", imported)
+	}
+
+	lookup, ok, err := target.Get(internalcmd.CacheKey("name", "Henrico, VA"))
+	if err != nil || !ok {
+		t.Fatalf("Get() Expected the imported lookup, got ok=%t, err=%v", ok, err)
+	}
+	if diff := cmp.Diff(henricoLookup, lookup); diff != "" {
+		t.Errorf("Get() mismatch (-want +got):\n%s", diff)
+	}
+
+	imported, err = target.Import(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatalf("Import() Unexpected error: %v", err)
+	}
+	if imported != 0 {
+		t.Errorf("Import() replaced %d entries with ones no newer, expected 0", imported)
+	}
+}
+
+func TestCache_ImportInvalid(t *testing.T) {
+	tests := map[string]string{
+		"not json":       "{not json}\n",
+		"missing scope":  `{"query":"23228","expires_at":"2030-01-01T00:00:00Z","lookup":{}}` + "\n",
+		"missing expiry": `{"scope":"name","query":"23228","lookup":{}}` + "\n",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			cache := openTestCache(t, time.Hour, time.Hour)
+			if _, err := cache.Import(strings.NewReader(input)); err == nil {
+				t.Errorf("Import() Expected error, but didn't get one")
+			}
+		})
+	}
+}
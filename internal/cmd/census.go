@@ -0,0 +1,409 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCensusURL is the Census Bureau geocoder used when Census.BaseURL is empty.
+const DefaultCensusURL = "https://geocoding.geo.census.gov/geocoder"
+
+// Address ranges and census geography searched when Census.Benchmark and Census.Vintage are empty.
+const (
+	DefaultCensusBenchmark = "Public_AR_Current"
+	DefaultCensusVintage   = "Current_Current"
+)
+
+// CensusBatchSize is the most addresses the Census geocoder accepts in one batch upload.
+const CensusBatchSize = 10000
+
+// Census looks US street addresses up with the Census Bureau geocoder, interpolating their coordinates along
+// the street, and reports the census tract and block each is in.
+// see https://geocoding.geo.census.gov/geocoder/Geocoding_Services_API.html
+type Census struct {
+	BaseURL     string       // Geocoder root, DefaultCensusURL when empty
+	Benchmark   string       // Address ranges to search, DefaultCensusBenchmark when empty
+	Vintage     string       // Census geography to report, DefaultCensusVintage when empty
+	BatchSize   int          // Most addresses per batch upload, CensusBatchSize when 0
+	Client      *http.Client // Client used for requests, http.DefaultClient when nil
+	BatchClient *http.Client // Client used for batch uploads, which take far longer than single lookups, Client when nil
+	Limiter     *RateLimiter // Paces every request, unlimited when nil
+	Retry       *RetryPolicy // Retries transient failures, never retries when nil
+}
+
+// censusResponse is the JSON response of the geographies endpoints.
+type censusResponse struct {
+	Result struct {
+		AddressMatches []censusMatch     `json:"addressMatches"`
+		Geographies    censusGeographies `json:"geographies"` // set by coordinates lookups only
+	} `json:"result"`
+}
+
+// censusMatch is an address the geocoder matched, placed along its street.
+type censusMatch struct {
+	MatchedAddress string `json:"matchedAddress"` // "4600 SILVER HILL RD, WASHINGTON, DC, 20233"
+	Coordinates    struct {
+		X float64 `json:"x"` // longitude
+		Y float64 `json:"y"` // latitude
+	} `json:"coordinates"`
+	AddressComponents struct {
+		State string `json:"state"`
+		Zip   string `json:"zip"`
+	} `json:"addressComponents"`
+	Geographies censusGeographies `json:"geographies"`
+}
+
+// censusGeographies are the areas containing a place, by layer, such as "States", "Counties" or "Census Tracts".
+type censusGeographies map[string][]censusArea
+
+type censusArea struct {
+	GEOID string `json:"GEOID"` // FIPS code, prefixed with those of the areas containing it
+	Name  string `json:"NAME"`
+}
+
+// first returns the first area of a layer, or none when the place is outside the layer.
+func (g censusGeographies) first(layer string) censusArea {
+	if areas := g[layer]; len(areas) > 0 {
+		return areas[0]
+	}
+	return censusArea{}
+}
+
+// block returns the census block, whose layer is named after the census it is from, such as "2020 Census Blocks".
+func (g censusGeographies) block() censusArea {
+	for layer, areas := range g {
+		if strings.HasSuffix(layer, "Census Blocks") && len(areas) > 0 {
+			return areas[0]
+		}
+	}
+	return censusArea{}
+}
+
+// Search implements Geocoder with a one line address, such as "4600 Silver Hill Rd, Washington, DC 20233".
+// see https://geocoding.geo.census.gov/geocoder/Geocoding_Services_API.html#_Toc150240669
+func (c *Census) Search(ctx context.Context, name string) ([]Place, error) {
+	q := url.Values{}
+	q.Set("address", name)
+	return c.search(ctx, "geographies/onelineaddress", q)
+}
+
+// SearchPostalCode implements Geocoder. The Census geocoder only finds street addresses, so a postal code on its
+// own matches nothing, without a request. It is not an error, as nothing failed: with the US as the default country
+// any five digits are a postal code.
+func (c *Census) SearchPostalCode(ctx context.Context, postal PostalCode) ([]Place, error) {
+	return nil, nil
+}
+
+// SearchStructured searches for a street address by its parts. Street is required, County and Country are
+// ignored, as every address the Census geocoder knows is in the US.
+func (c *Census) SearchStructured(ctx context.Context, query StructuredQuery) ([]Place, error) {
+	if strings.TrimSpace(query.Street) == "" {
+		return nil, errors.New("the Census geocoder needs the street of an address")
+	}
+
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"street": query.Street,
+		"city":   query.City,
+		"state":  query.State,
+		"zip":    query.PostalCode,
+	} {
+		if value = strings.TrimSpace(value); value != "" {
+			q.Set(key, value)
+		}
+	}
+	return c.search(ctx, "geographies/address", q)
+}
+
+// Reverse implements Geocoder. The Census geocoder has no addresses for coordinates, so the single place
+// returned is the county they are in, along with its census tract and block. Coordinates outside the US have none.
+// The limit is ignored.
+func (c *Census) Reverse(ctx context.Context, lat, lon float64, limit int) ([]Place, error) {
+	q := url.Values{}
+	q.Set("x", strconv.FormatFloat(lon, 'f', -1, 64))
+	q.Set("y", strconv.FormatFloat(lat, 'f', -1, 64))
+
+	response, err := c.get(ctx, "geographies/coordinates", q)
+	if err != nil {
+		return nil, err
+	}
+
+	geographies := response.Result.Geographies
+	state := geographies.first("States")
+	if state.GEOID == "" {
+		return nil, nil
+	}
+	return []Place{{
+		Name:      geographies.first("Counties").Name,
+		State:     state.Name,
+		Country:   "US",
+		Lat:       lat,
+		Lon:       lon,
+		TractFIPS: geographies.first("Census Tracts").GEOID,
+		BlockFIPS: geographies.block().GEOID,
+	}}, nil
+}
+
+// SearchBatch implements BatchGeocoder, splitting every name into the parts of a street address for Batch.
+func (c *Census) SearchBatch(ctx context.Context, names []string) ([][]Place, error) {
+	addresses := make([]StructuredQuery, len(names))
+	for i, name := range names {
+		addresses[i] = parseStreetAddress(name)
+	}
+	return c.Batch(ctx, addresses)
+}
+
+// Batch looks up many street addresses, uploading them BatchSize at a time. It returns the places matching each
+// address, in the order of addresses. An address matching several places, or without a Street, matches none.
+// see https://geocoding.geo.census.gov/geocoder/Geocoding_Services_API.html#_Toc150240673
+func (c *Census) Batch(ctx context.Context, addresses []StructuredQuery) ([][]Place, error) {
+	size := c.BatchSize
+	if size <= 0 {
+		size = CensusBatchSize
+	}
+
+	places := make([][]Place, len(addresses))
+	for start := 0; start < len(addresses); start += size {
+		end := min(start+size, len(addresses))
+		if err := c.batch(ctx, addresses[start:end], places[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return places, nil
+}
+
+// batch uploads one batch of addresses, setting the places of each matched address in found.
+// Every address is identified by its index, as the geocoder returns them in no particular order.
+func (c *Census) batch(ctx context.Context, addresses []StructuredQuery, found [][]Place) error {
+	var file bytes.Buffer
+	w := csv.NewWriter(&file)
+	for i, a := range addresses {
+		if strings.TrimSpace(a.Street) == "" {
+			continue
+		}
+		if err := w.Write([]string{strconv.Itoa(i), a.Street, a.City, a.State, a.PostalCode}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if file.Len() == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("benchmark", c.benchmark())
+	_ = form.WriteField("vintage", c.vintage())
+	part, err := form.CreateFormFile("addressFile", "addresses.csv")
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(file.Bytes()); err != nil {
+		return err
+	}
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	uri, err := c.url("geographies/addressbatch")
+	if err != nil {
+		return err
+	}
+	client := c.BatchClient
+	if client == nil {
+		client = c.Client
+	}
+	// The upload only looks addresses up, so it is retried like any GET, see RetryPolicy.
+	result, err := httpDo(ctx, client, c.Limiter, c.Retry, http.MethodPost, uri.String(),
+		http.Header{"Content-Type": {form.FormDataContentType()}}, body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r := csv.NewReader(bytes.NewReader(result))
+	r.FieldsPerRecord = -1 // addresses that did not match have no fields after the match indicator
+	records, err := r.ReadAll()
+	if err != nil {
+		return fmt.Errorf("unreadable batch results: %w", err)
+	}
+	for _, record := range records {
+		i, err := strconv.Atoi(record[0])
+		if err != nil || i < 0 || i >= len(found) {
+			return fmt.Errorf("unexpected address id '%s' in batch results", record[0])
+		}
+		if place, ok := censusBatchPlace(record); ok {
+			found[i] = []Place{place}
+		}
+	}
+	return nil
+}
+
+// censusBatchPlace reads a matched address of the batch results, whose fields are the id, the address as
+// uploaded, "Match", the match type, the matched address, "<lon>,<lat>", the TIGER/Line id and side of the
+// street, then the state, county, tract and block codes.
+func censusBatchPlace(record []string) (Place, bool) {
+	if len(record) < 12 || record[2] != "Match" {
+		return Place{}, false
+	}
+
+	lon, lat, _ := strings.Cut(record[5], ",")
+	p := Place{
+		Name:    streetAndCity(record[4]),
+		Country: "US",
+	}
+	p.Lon, _ = strconv.ParseFloat(lon, 64)
+	p.Lat, _ = strconv.ParseFloat(lat, 64)
+
+	// The matched address ends in the state code and ZIP code, as in "4600 SILVER HILL RD, WASHINGTON, DC, 20233".
+	if parts := strings.Split(record[4], ","); len(parts) >= 3 {
+		p.State = usStates[strings.TrimSpace(parts[len(parts)-2])]
+		p.Zip = strings.TrimSpace(parts[len(parts)-1])
+	}
+	if tract := record[8] + record[9] + record[10]; len(tract) == 11 {
+		p.TractFIPS = tract
+		p.BlockFIPS = tract + record[11]
+	}
+	return p, true
+}
+
+// search calls a geographies endpoint that matches addresses.
+func (c *Census) search(ctx context.Context, endpoint string, q url.Values) ([]Place, error) {
+	response, err := c.get(ctx, endpoint, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var places []Place
+	for _, m := range response.Result.AddressMatches {
+		places = append(places, m.place())
+	}
+	return places, nil
+}
+
+// get calls an endpoint with the benchmark and vintage every request shares.
+func (c *Census) get(ctx context.Context, endpoint string, q url.Values) (*censusResponse, error) {
+	uri, err := c.url(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q.Set("benchmark", c.benchmark())
+	q.Set("vintage", c.vintage())
+	q.Set("format", "json")
+	uri.RawQuery = q.Encode()
+
+	body, err := httpGet(ctx, c.Client, c.Limiter, c.Retry, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response censusResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Census) url(endpoint string) (*url.URL, error) {
+	uri, err := baseURL(c.BaseURL, DefaultCensusURL)
+	if err != nil {
+		return nil, err
+	}
+	return uri.JoinPath(endpoint), nil
+}
+
+func (c *Census) benchmark() string {
+	if c.Benchmark == "" {
+		return DefaultCensusBenchmark
+	}
+	return c.Benchmark
+}
+
+func (c *Census) vintage() string {
+	if c.Vintage == "" {
+		return DefaultCensusVintage
+	}
+	return c.Vintage
+}
+
+func (m censusMatch) place() Place {
+	state := m.Geographies.first("States").Name
+	if state == "" {
+		state = usStates[m.AddressComponents.State]
+	}
+	return Place{
+		Name:      streetAndCity(m.MatchedAddress),
+		State:     state,
+		Country:   "US",
+		Zip:       m.AddressComponents.Zip,
+		Lat:       m.Coordinates.Y,
+		Lon:       m.Coordinates.X,
+		TractFIPS: m.Geographies.first("Census Tracts").GEOID,
+		BlockFIPS: m.Geographies.block().GEOID,
+	}
+}
+
+// streetAndCity drops the state and ZIP code ending a matched address, which are reported on their own.
+func streetAndCity(matched string) string {
+	parts := strings.Split(matched, ",")
+	if len(parts) < 3 {
+		return matched
+	}
+	return strings.TrimSpace(strings.Join(parts[:len(parts)-2], ","))
+}
+
+var zipPattern = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+
+// parseStreetAddress splits a one line US address, such as "4600 Silver Hill Rd, Washington, DC 20233", into
+// the street, city, state and ZIP code columns of a batch upload. The street is everything before the first
+// comma, and the city everything between it and the state or ZIP code. An address without commas is all street.
+func parseStreetAddress(address string) StructuredQuery {
+	var parts []string
+	for _, part := range strings.Split(address, ",") {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) < 2 {
+		return StructuredQuery{Street: strings.Join(parts, "")}
+	}
+
+	var query StructuredQuery
+	if c, ok := CountryByName(parts[len(parts)-1]); ok && c.Alpha2 == "US" {
+		parts = parts[:len(parts)-1]
+	}
+
+	last := parts[len(parts)-1]
+	if i := strings.LastIndex(last, " "); zipPattern.MatchString(last[i+1:]) {
+		query.PostalCode = last[i+1:]
+		last = strings.TrimSpace(last[:max(i, 0)])
+	}
+	if last == "" {
+		parts = parts[:len(parts)-1]
+	} else {
+		parts[len(parts)-1] = last
+	}
+
+	// The state follows the city, so a second part on its own is the city, unless a ZIP code follows it.
+	if len(parts) > 2 || (len(parts) == 2 && query.PostalCode != "") {
+		if _, ok := USStateByName(parts[len(parts)-1]); ok {
+			query.State = parts[len(parts)-1]
+			parts = parts[:len(parts)-1]
+		}
+	}
+
+	if len(parts) > 0 {
+		query.Street = parts[0]
+		query.City = strings.Join(parts[1:], ", ")
+	}
+	return query
+}
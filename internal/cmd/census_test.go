@@ -0,0 +1,296 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var silverHillPlace = internalcmd.Place{
+	Name: "4600 SILVER HILL RD, WASHINGTON", State: "District of Columbia", Country: "US", Zip: "20233",
+	Lat: 38.84601622386617, Lon: -76.92748724230096, TractFIPS: "11001007601", BlockFIPS: "110010076011013",
+}
+
+// censusFixture reads a response recorded from the Census geocoder.
+func censusFixture(t *testing.T, name string) []byte {
+	body, err := os.ReadFile(filepath.Join("testdata", "census", name))
+	if err != nil {
+		t.Fatalf("censusFixture() Unexpected error: %v", err)
+	}
+	return body
+}
+
+// censusUpload is a batch the Census stub received.
+type censusUpload struct {
+	Fields map[string]string
+	Rows   [][]string
+}
+
+// censusStub serves the recorded Census responses, recording every request and batch upload it saw.
+func censusStub(t *testing.T, requests *[]*http.Request, uploads *[]censusUpload) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*requests = append(*requests, req)
+		q := req.URL.Query()
+		switch {
+		case req.URL.Path == "/geocoder/geographies/addressbatch":
+			upload, err := readCensusUpload(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			*uploads = append(*uploads, upload)
+
+			// Answer each uploaded address with the recorded result for its street, under its id.
+			r := csv.NewReader(bytes.NewReader(censusFixture(t, "addressbatch.csv")))
+			r.FieldsPerRecord = -1
+			recorded, _ := r.ReadAll()
+			out := csv.NewWriter(w)
+			for _, row := range upload.Rows {
+				for _, record := range recorded {
+					if strings.HasPrefix(record[1], row[1]+",") {
+						_ = out.Write(append([]string{row[0]}, record[1:]...))
+					}
+				}
+			}
+			out.Flush()
+		case req.URL.Path == "/geocoder/geographies/coordinates" && q.Get("y") == "30":
+			_, _ = w.Write(censusFixture(t, "ocean.json"))
+		case req.URL.Path == "/geocoder/geographies/coordinates":
+			_, _ = w.Write(censusFixture(t, "coordinates.json"))
+		case q.Get("address") == "" && q.Get("street") == "":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(censusFixture(t, "error.json"))
+		case strings.Contains(q.Get("address"), "Nowhere"):
+			_, _ = w.Write(censusFixture(t, "nomatch.json"))
+		default:
+			_, _ = w.Write(censusFixture(t, "onelineaddress.json"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func readCensusUpload(r *http.Request) (censusUpload, error) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		return censusUpload{}, err
+	}
+	upload := censusUpload{Fields: map[string]string{}}
+	for key, values := range r.MultipartForm.Value {
+		upload.Fields[key] = values[0]
+	}
+	file, _, err := r.FormFile("addressFile")
+	if err != nil {
+		return censusUpload{}, err
+	}
+	defer file.Close()
+	upload.Rows, err = csv.NewReader(file).ReadAll()
+	return upload, err
+}
+
+func TestCensus(t *testing.T) {
+	var requests []*http.Request
+	server := censusStub(t, &requests, nil)
+	c := &internalcmd.Census{BaseURL: server.URL + "/geocoder"}
+
+	shared := url.Values{"benchmark": {"Public_AR_Current"}, "vintage": {"Current_Current"}, "format": {"json"}}
+	with := func(values url.Values) url.Values {
+		for key, v := range shared {
+			values[key] = v
+		}
+		return values
+	}
+
+	tests := map[string]struct {
+		lookup        func() ([]internalcmd.Place, error)
+		expectedPath  string
+		expectedQuery url.Values
+		expected      []internalcmd.Place
+	}{
+		"Search": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return c.Search(context.Background(), "4600 Silver Hill Rd, Washington, DC 20233")
+			},
+			expectedPath:  "/geocoder/geographies/onelineaddress",
+			expectedQuery: with(url.Values{"address": {"4600 Silver Hill Rd, Washington, DC 20233"}}),
+			expected:      []internalcmd.Place{silverHillPlace},
+		},
+		"Search without matches": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return c.Search(context.Background(), "1 Nowhere Ln, Nowhere, ZZ")
+			},
+			expectedPath:  "/geocoder/geographies/onelineaddress",
+			expectedQuery: with(url.Values{"address": {"1 Nowhere Ln, Nowhere, ZZ"}}),
+		},
+		"SearchStructured": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return c.SearchStructured(context.Background(), internalcmd.StructuredQuery{
+					Street: "4600 Silver Hill Rd", City: "Washington", State: "DC", PostalCode: "20233", Country: "US",
+				})
+			},
+			expectedPath: "/geocoder/geographies/address",
+			expectedQuery: with(url.Values{
+				"street": {"4600 Silver Hill Rd"}, "city": {"Washington"}, "state": {"DC"}, "zip": {"20233"},
+			}),
+			expected: []internalcmd.Place{silverHillPlace},
+		},
+		"Reverse": {
+			lookup:        func() ([]internalcmd.Place, error) { return c.Reverse(context.Background(), 37.5385, -77.4343, 5) },
+			expectedPath:  "/geocoder/geographies/coordinates",
+			expectedQuery: with(url.Values{"x": {"-77.4343"}, "y": {"37.5385"}}),
+			expected: []internalcmd.Place{{
+				Name: "Richmond city", State: "Virginia", Country: "US", Lat: 37.5385, Lon: -77.4343,
+				TractFIPS: "51760020400", BlockFIPS: "517600204002004",
+			}},
+		},
+		"Reverse outside the US": {
+			lookup:        func() ([]internalcmd.Place, error) { return c.Reverse(context.Background(), 30, -40, 5) },
+			expectedPath:  "/geocoder/geographies/coordinates",
+			expectedQuery: with(url.Values{"x": {"-40"}, "y": {"30"}}),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests = nil
+			places, err := tc.lookup()
+			if err != nil {
+				t.Fatalf("%s() Unexpected error: %v", name, err)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("%s() Expected 1 request, got %d", name, len(requests))
+			}
+			if requests[0].URL.Path != tc.expectedPath {
+				t.Errorf("%s() Unexpected path: %s, expected %s", name, requests[0].URL.Path, tc.expectedPath)
+			}
+			if diff := cmp.Diff(tc.expectedQuery, requests[0].URL.Query()); diff != "" {
+				t.Errorf("%s() query mismatch (-want +got):\n%s", name, diff)
+			}
+			if diff := cmp.Diff(tc.expected, places); diff != "" {
+				t.Errorf("%s() mismatch (-want +got):\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestCensus_Errors(t *testing.T) {
+	var requests []*http.Request
+	server := censusStub(t, &requests, nil)
+	c := &internalcmd.Census{BaseURL: server.URL + "/geocoder"}
+
+	_, err := c.Search(context.Background(), "")
+	if !errors.Is(err, internalcmd.ErrUpstream) {
+		t.Errorf("Search() Unexpected error: %v, expected %v", err, internalcmd.ErrUpstream)
+	}
+	if err != nil && !strings.Contains(err.Error(), "Address cannot be empty") {
+		t.Errorf("Search() error '%v' does not explain the failure", err)
+	}
+
+	if places, err := c.SearchPostalCode(context.Background(), internalcmd.PostalCode{Code: "23228", Country: "US"}); err != nil || places != nil {
+		t.Errorf("SearchPostalCode() = %v, %v, expected no places and no error", places, err)
+	}
+	if _, err = c.SearchStructured(context.Background(), internalcmd.StructuredQuery{City: "Washington"}); err == nil {
+		t.Errorf("SearchStructured() Expected error, but didn't get one")
+	}
+	if len(requests) != 1 {
+		t.Errorf("Census made %d requests, expected only the search", len(requests))
+	}
+}
+
+func TestCensus_SearchBatch(t *testing.T) {
+	names := []string{
+		"4600 Silver Hill Rd, Washington, DC, 20233",
+		"1 Nowhere Ln, Nowhere, ZZ",
+		"100 Main St, Springfield",
+		"",
+	}
+
+	tests := map[string]struct {
+		batchSize       int
+		expectedUploads []censusUpload
+	}{
+		"one upload": {
+			expectedUploads: []censusUpload{{
+				Fields: map[string]string{"benchmark": "Public_AR_Current", "vintage": "Current_Current"},
+				Rows: [][]string{
+					{"0", "4600 Silver Hill Rd", "Washington", "DC", "20233"},
+					{"1", "1 Nowhere Ln", "Nowhere, ZZ", "", ""},
+					{"2", "100 Main St", "Springfield", "", ""},
+				},
+			}},
+		},
+		"uploads of BatchSize addresses": {
+			batchSize: 2,
+			expectedUploads: []censusUpload{
+				{
+					Fields: map[string]string{"benchmark": "Public_AR_Current", "vintage": "Current_Current"},
+					Rows: [][]string{
+						{"0", "4600 Silver Hill Rd", "Washington", "DC", "20233"},
+						{"1", "1 Nowhere Ln", "Nowhere, ZZ", "", ""},
+					},
+				},
+				{
+					Fields: map[string]string{"benchmark": "Public_AR_Current", "vintage": "Current_Current"},
+					Rows:   [][]string{{"0", "100 Main St", "Springfield", "", ""}},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requests []*http.Request
+			var uploads []censusUpload
+			server := censusStub(t, &requests, &uploads)
+			c := &internalcmd.Census{BaseURL: server.URL + "/geocoder", BatchSize: tc.batchSize}
+
+			found, err := c.SearchBatch(context.Background(), names)
+			if err != nil {
+				t.Fatalf("SearchBatch() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedUploads, uploads); diff != "" {
+				t.Errorf("SearchBatch() uploads mismatch (-want +got):\n%s", diff)
+			}
+
+			expected := [][]internalcmd.Place{{silverHillPlace}, nil, nil, nil}
+			if diff := cmp.Diff(expected, found); diff != "" {
+				t.Errorf("SearchBatch() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCensus_Batch(t *testing.T) {
+	var requests []*http.Request
+	var uploads []censusUpload
+	server := censusStub(t, &requests, &uploads)
+	c := &internalcmd.Census{BaseURL: server.URL + "/geocoder", Benchmark: "Public_AR_Census2020", Vintage: "Census2020_Census2020"}
+
+	found, err := c.Batch(context.Background(), []internalcmd.StructuredQuery{
+		{City: "Washington"},
+		{Street: "4600 Silver Hill Rd", City: "Washington", State: "DC", PostalCode: "20233"},
+	})
+	if err != nil {
+		t.Fatalf("Batch() Unexpected error: %v", err)
+	}
+
+	expectedUploads := []censusUpload{{
+		Fields: map[string]string{"benchmark": "Public_AR_Census2020", "vintage": "Census2020_Census2020"},
+		Rows:   [][]string{{"1", "4600 Silver Hill Rd", "Washington", "DC", "20233"}},
+	}}
+	if diff := cmp.Diff(expectedUploads, uploads); diff != "" {
+		t.Errorf("Batch() uploads mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([][]internalcmd.Place{nil, {silverHillPlace}}, found); diff != "" {
+		t.Errorf("Batch() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,502 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// stubTransport answers every request with a fixed status and body, recording the requests it saw.
+type stubTransport struct {
+	StatusCode int
+	Body       string
+	Requests   []*http.Request
+}
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.Requests = append(s.Requests, req)
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: s.StatusCode,
+		Body:       io.NopCloser(strings.NewReader(s.Body)),
+		Header:     http.Header{},
+		Request:    req,
+	}, nil
+}
+
+func TestDirectGeocoding_Client(t *testing.T) {
+	transport := &stubTransport{
+		StatusCode: http.StatusOK,
+		Body:       `[{"name":"Henrico","lat":37.5,"lon":-77.3,"country":"US","state":"Virginia"}]`,
+	}
+	g := internalcmd.DirectGeocoding{
+		Key:    "test-key",
+		Client: &http.Client{Transport: transport},
+	}
+
+	locations, err := g.LocationByName("Henrico, VA")
+	if err != nil {
+		t.Fatalf("LocationByName() Unexpected error: %v", err)
+	}
+	if len(transport.Requests) != 1 {
+		t.Fatalf("Expected the configured client to make 1 request, made %d", len(transport.Requests))
+	}
+
+	expected := []internalcmd.NameResult{
+		{Name: "Henrico", Lat: 37.5, Lon: -77.3, Country: "US", State: "Virginia"},
+	}
+	if diff := cmp.Diff(expected, locations); diff != "" {
+		t.Errorf("LocationByName() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDirectGeocoding_Context(t *testing.T) {
+	transport := &stubTransport{StatusCode: http.StatusOK, Body: `[]`}
+	g := internalcmd.DirectGeocoding{
+		Key:    "test-key",
+		Client: &http.Client{Transport: transport},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := map[string]func() error{
+		"LocationByNameContext": func() error {
+			_, err := g.LocationByNameContext(ctx, "Henrico, VA")
+			return err
+		},
+		"LocationByZipContext": func() error {
+			_, err := g.LocationByZipContext(ctx, "23228", "")
+			return err
+		},
+		"LocationByCoordinatesContext": func() error {
+			_, err := g.LocationByCoordinatesContext(ctx, 37.5, -77.3, 1)
+			return err
+		},
+	}
+
+	for name, lookup := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := lookup(); !errors.Is(err, context.Canceled) {
+				t.Fatalf("%s() Expected context.Canceled, got: %v", name, err)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_BaseURL(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if strings.HasSuffix(r.URL.Path, "/zip") {
+			_, _ = w.Write([]byte(`{"zip":"23228","name":"Henrico County","lat":37.4638,"lon":-77.398,"country":"US"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	g := internalcmd.DirectGeocoding{
+		Key:     "test-key",
+		BaseURL: server.URL + "/proxy/openweather",
+		Country: "US",
+	}
+	limited := g
+	limited.Limit = 3
+
+	tests := map[string]struct {
+		lookup        func() error
+		expectedPath  string
+		expectedQuery url.Values
+	}{
+		"LocationByName": {
+			lookup: func() error {
+				_, err := g.LocationByName("Henrico, VA")
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/direct",
+			expectedQuery: url.Values{"q": {"Henrico, VA, US"}, "appid": {"test-key"}},
+		},
+		"LocationByName naming a country": {
+			lookup: func() error {
+				_, err := g.LocationByName("Paris, France")
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/direct",
+			expectedQuery: url.Values{"q": {"Paris, FR"}, "appid": {"test-key"}},
+		},
+		"LocationByName with a limit": {
+			lookup: func() error {
+				_, err := limited.LocationByName("Richmond")
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/direct",
+			expectedQuery: url.Values{"q": {"Richmond, US"}, "limit": {"3"}, "appid": {"test-key"}},
+		},
+		"LocationByZip": {
+			lookup: func() error {
+				_, err := g.LocationByZip("23228", "")
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/zip",
+			expectedQuery: url.Values{"zip": {"23228"}, "appid": {"test-key"}},
+		},
+		"LocationByZip with a country": {
+			lookup: func() error {
+				_, err := g.LocationByZip("K1A 0B6", "CA")
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/zip",
+			expectedQuery: url.Values{"zip": {"K1A 0B6,CA"}, "appid": {"test-key"}},
+		},
+		"LocationByCoordinates": {
+			lookup: func() error {
+				_, err := g.LocationByCoordinates(37.5385087, -77.43428, 2)
+				return err
+			},
+			expectedPath:  "/proxy/openweather/geo/1.0/reverse",
+			expectedQuery: url.Values{"lat": {"37.5385087"}, "lon": {"-77.43428"}, "limit": {"2"}, "appid": {"test-key"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests = nil
+			if err := tc.lookup(); err != nil {
+				t.Fatalf("%s() Unexpected error: %v", name, err)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("%s() Expected 1 request to the base URL, got %d", name, len(requests))
+			}
+			if requests[0].URL.Path != tc.expectedPath {
+				t.Errorf("%s() Unexpected path: %s, expected %s", name, requests[0].URL.Path, tc.expectedPath)
+			}
+			if diff := cmp.Diff(tc.expectedQuery, requests[0].URL.Query()); diff != "" {
+				t.Errorf("%s() query mismatch (-want +got):\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_LocationByNameSplitsState(t *testing.T) {
+	var searched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		searched = append(searched, q)
+		switch q {
+		case "richmond, VA, US":
+			_, _ = w.Write([]byte(`[{"name":"Richmond","lat":37.5385,"lon":-77.4343,"country":"US","state":"Virginia"}]`))
+		case "Fort Washington, US":
+			_, _ = w.Write([]byte(`[{"name":"Fort Washington","lat":38.7073,"lon":-77.0230,"country":"US","state":"Maryland"}]`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	g := internalcmd.DirectGeocoding{Key: "test-key", BaseURL: server.URL, Country: "US"}
+
+	tests := map[string]struct {
+		name             string
+		expectedSearches []string
+		expectedNames    []string
+	}{
+		"state split off once the name matches nothing": {
+			name:             "richmond va",
+			expectedSearches: []string{"richmond va, US", "richmond, VA, US"},
+			expectedNames:    []string{"Richmond"},
+		},
+		"place ending in a state name is found as given": {
+			name:             "Fort Washington",
+			expectedSearches: []string{"Fort Washington, US"},
+			expectedNames:    []string{"Fort Washington"},
+		},
+		"nothing to split off": {
+			name:             "Fallafelville",
+			expectedSearches: []string{"Fallafelville, US"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			searched = nil
+			results, err := g.LocationByName(tc.name)
+			if err != nil {
+				t.Fatalf("LocationByName() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedSearches, searched); diff != "" {
+				t.Errorf("LocationByName() searches mismatch (-want +got):\n%s", diff)
+			}
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Name)
+			}
+			if diff := cmp.Diff(tc.expectedNames, names); diff != "" {
+				t.Errorf("LocationByName() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_InvalidBaseURL(t *testing.T) {
+	tests := map[string]struct {
+		baseURL       string
+		expectedError string
+	}{
+		"missing scheme": {
+			baseURL:       "api.openweathermap.org",
+			expectedError: "invalid API base URL 'api.openweathermap.org': scheme must be 'http' or 'https'",
+		},
+		"unsupported scheme": {
+			baseURL:       "ftp://api.openweathermap.org",
+			expectedError: "invalid API base URL 'ftp://api.openweathermap.org': scheme must be 'http' or 'https'",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := internalcmd.DirectGeocoding{Key: "test-key", BaseURL: tc.baseURL}
+			_, err := g.LocationByName("Henrico, VA")
+			if err == nil {
+				t.Fatalf("LocationByName() Expected error, but didn't get one")
+			}
+			if err.Error() != tc.expectedError {
+				t.Fatalf("LocationByName() Unexpected error: %s, expected %s", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_Resolve(t *testing.T) {
+	tests := map[string]struct {
+		query       string
+		lang        string
+		statusCode  int
+		body        string
+		expectedErr error
+		expected    internalcmd.Lookup
+	}{
+		"numeric query uses the zip endpoint": {
+			query:      "23228",
+			statusCode: http.StatusOK,
+			body:       `{"zip":"23228","name":"Henrico County","lat":37.4638,"lon":-77.398,"country":"US"}`,
+			expected: internalcmd.NewZipLookup("23228", &internalcmd.ZipResult{
+				Zip: "23228", Name: "Henrico County", Lat: 37.4638, Lon: -77.398, Country: "US",
+			}),
+		},
+		"unknown zip is no match": {
+			query:      "99999",
+			statusCode: http.StatusNotFound,
+			body:       `{"cod":"404","message":"not found"}`,
+			expected:   internalcmd.Lookup{Query: "99999", Type: internalcmd.LookupZip},
+		},
+		"zip server error is an error": {
+			query:       "23228",
+			statusCode:  http.StatusBadGateway,
+			body:        `<html>bad gateway</html>`,
+			expectedErr: internalcmd.ErrUpstream,
+			expected:    internalcmd.Lookup{Query: "23228", Type: internalcmd.LookupZip},
+		},
+		"name query uses the name endpoint": {
+			query:      "Henrico, VA",
+			statusCode: http.StatusOK,
+			body:       `[{"name":"Henrico","lat":37.495702,"lon":-77.335257,"country":"US","state":"Virginia"}]`,
+			expected:   henricoLookup,
+		},
+		"name query in another language": {
+			query:      "Henrico, VA",
+			lang:       "es",
+			statusCode: http.StatusOK,
+			body:       `[{"name":"Henrico","local_names":{"en":"Henrico","es":"Condado de Henrico"},"lat":37.495702,"lon":-77.335257,"country":"US","state":"Virginia"}]`,
+			expected: internalcmd.NewNameLookup("Henrico, VA", internalcmd.LookupName, []internalcmd.NameResult{
+				{Name: "Condado de Henrico", Lat: 37.495702, Lon: -77.335257, Country: "US", State: "Virginia"},
+			}),
+		},
+		"name server error is an error": {
+			query:       "Henrico, VA",
+			statusCode:  http.StatusInternalServerError,
+			expectedErr: internalcmd.ErrUpstream,
+			expected:    internalcmd.Lookup{Query: "Henrico, VA", Type: internalcmd.LookupName},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := internalcmd.DirectGeocoding{
+				Key:    "test-key",
+				Lang:   tc.lang,
+				Client: &http.Client{Transport: &stubTransport{StatusCode: tc.statusCode, Body: tc.body}},
+			}
+
+			lookup, err := g.Resolve(context.Background(), tc.query)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Resolve() Unexpected error: %v, expected %v", err, tc.expectedErr)
+			}
+
+			if diff := cmp.Diff(tc.expected, lookup); diff != "" {
+				t.Errorf("Resolve() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_Errors(t *testing.T) {
+	tests := map[string]struct {
+		statusCode    int
+		body          string
+		expectedErr   error
+		expectedError string
+	}{
+		"unauthorized": {
+			statusCode:    http.StatusUnauthorized,
+			body:          `{"cod":401,"message":"Invalid API key. Please see https://openweathermap.org/faq#error401 for more info."}`,
+			expectedErr:   internalcmd.ErrUnauthorized,
+			expectedError: "invalid API key (status code 401): Invalid API key. Please see https://openweathermap.org/faq#error401 for more info.",
+		},
+		"forbidden": {
+			statusCode:    http.StatusForbidden,
+			body:          `{"message":"Forbidden"}`,
+			expectedErr:   internalcmd.ErrUnauthorized,
+			expectedError: "invalid API key (status code 403): Forbidden",
+		},
+		"not found": {
+			statusCode:    http.StatusNotFound,
+			body:          `{"cod":"404","message":"not found"}`,
+			expectedErr:   internalcmd.ErrNotFound,
+			expectedError: "not found (status code 404): not found",
+		},
+		"rate limited": {
+			statusCode:    http.StatusTooManyRequests,
+			body:          `{"cod":429,"message":"Your account is temporary blocked due to exceeding of requests limitation of your subscription type."}`,
+			expectedErr:   internalcmd.ErrRateLimited,
+			expectedError: "rate limited (status code 429): Your account is temporary blocked due to exceeding of requests limitation of your subscription type.",
+		},
+		"upstream failure": {
+			statusCode:    http.StatusBadGateway,
+			body:          `<html>bad gateway</html>`,
+			expectedErr:   internalcmd.ErrUpstream,
+			expectedError: "unexpected response (status code 502)",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := internalcmd.DirectGeocoding{
+				Key:    "test-key",
+				Client: &http.Client{Transport: &stubTransport{StatusCode: tc.statusCode, Body: tc.body}},
+			}
+
+			_, err := g.LocationByName("Henrico, VA")
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("LocationByName() Unexpected error: %v, expected %v", err, tc.expectedErr)
+			}
+			if err.Error() != tc.expectedError {
+				t.Errorf("LocationByName() Unexpected error: %s, expected %s", err, tc.expectedError)
+			}
+
+			var apiErr *internalcmd.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("LocationByName() Expected an *APIError, got %T", err)
+			}
+			if apiErr.StatusCode != tc.statusCode || string(apiErr.Body) != tc.body {
+				t.Errorf("LocationByName() Unexpected response: %d %s, expected %d %s", apiErr.StatusCode, apiErr.Body, tc.statusCode, tc.body)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_ZipNotFound(t *testing.T) {
+	tests := map[string]struct {
+		statusCode int
+		body       string
+	}{
+		"404 response":         {statusCode: http.StatusNotFound, body: `{"cod":"404","message":"not found"}`},
+		"response with no zip": {statusCode: http.StatusOK, body: `{}`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := internalcmd.DirectGeocoding{
+				Key:    "test-key",
+				Client: &http.Client{Transport: &stubTransport{StatusCode: tc.statusCode, Body: tc.body}},
+			}
+
+			_, err := g.LocationByZip("99999", "")
+			if !errors.Is(err, internalcmd.ErrNotFound) {
+				t.Fatalf("LocationByZip() Unexpected error: %v, expected %v", err, internalcmd.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_ResolvePostalCode(t *testing.T) {
+	tests := map[string]struct {
+		query       string
+		country     string
+		expectedZip string
+	}{
+		"ZIP code":                  {query: "23228", expectedZip: "23228,US"},
+		"ZIP+4 code":                {query: "23228-1234", expectedZip: "23228,US"},
+		"Canadian postal code":      {query: "K1A 0B6", expectedZip: "K1A 0B6,CA"},
+		"UK postcode":               {query: "sw1a 1aa", expectedZip: "SW1A,GB"},
+		"Dutch postcode":            {query: "1012 AB", expectedZip: "1012 AB,NL"},
+		"code qualified by country": {query: "10115,DE", expectedZip: "10115,DE"},
+		"code in the --country":     {query: "75001", country: "FR", expectedZip: "75001,FR"},
+		"numeric query in a country without known formats": {query: "2000", country: "ZA", expectedZip: "2000,ZA"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := &stubTransport{StatusCode: http.StatusOK, Body: `{"zip":"10115","name":"Berlin","lat":52.5323,"lon":13.3846,"country":"DE"}`}
+			g := internalcmd.DirectGeocoding{
+				Key:     "test-key",
+				Country: tc.country,
+				Client:  &http.Client{Transport: transport},
+			}
+
+			lookup, err := g.Resolve(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("Resolve() Unexpected error: %v", err)
+			}
+			if lookup.Type != internalcmd.LookupZip {
+				t.Errorf("Resolve() Unexpected lookup type: %s, expected %s", lookup.Type, internalcmd.LookupZip)
+			}
+			if zip := transport.Requests[0].URL.Query().Get("zip"); zip != tc.expectedZip {
+				t.Errorf("Resolve() Unexpected zip: %s, expected %s", zip, tc.expectedZip)
+			}
+		})
+	}
+}
+
+func TestNameResult_LocalName(t *testing.T) {
+	paris := internalcmd.NameResult{Name: "Paris", LocalNames: map[string]string{"en": "Paris", "el": "Παρίσι", "pt": ""}}
+
+	tests := map[string]struct {
+		lang     string
+		expected string
+	}{
+		"known language":              {lang: "el", expected: "Παρίσι"},
+		"language in any case":        {lang: "EL", expected: "Παρίσι"},
+		"regional language":           {lang: "el-GR", expected: "Παρίσι"},
+		"unknown language falls back": {lang: "es", expected: "Paris"},
+		"empty local name falls back": {lang: "pt", expected: "Paris"},
+		"no language":                 {lang: "", expected: "Paris"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := paris.LocalName(tc.lang); got != tc.expected {
+				t.Errorf("LocalName() = '%s', expected '%s'", got, tc.expected)
+			}
+		})
+	}
+}
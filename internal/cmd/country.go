@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// Country is an ISO 3166-1 country.
+type Country struct {
+	Alpha2 string
+	Alpha3 string
+	Name   string
+}
+
+// countries are the ISO 3166-1 countries, by their common English name.
+// see https://www.iso.org/iso-3166-country-codes.html
+var countries = []Country{
+	{"AD", "AND", "Andorra"},
+	{"AE", "ARE", "United Arab Emirates"},
+	{"AF", "AFG", "Afghanistan"},
+	{"AG", "ATG", "Antigua and Barbuda"},
+	{"AI", "AIA", "Anguilla"},
+	{"AL", "ALB", "Albania"},
+	{"AM", "ARM", "Armenia"},
+	{"AO", "AGO", "Angola"},
+	{"AQ", "ATA", "Antarctica"},
+	{"AR", "ARG", "Argentina"},
+	{"AS", "ASM", "American Samoa"},
+	{"AT", "AUT", "Austria"},
+	{"AU", "AUS", "Australia"},
+	{"AW", "ABW", "Aruba"},
+	{"AX", "ALA", "Åland Islands"},
+	{"AZ", "AZE", "Azerbaijan"},
+	{"BA", "BIH", "Bosnia and Herzegovina"},
+	{"BB", "BRB", "Barbados"},
+	{"BD", "BGD", "Bangladesh"},
+	{"BE", "BEL", "Belgium"},
+	{"BF", "BFA", "Burkina Faso"},
+	{"BG", "BGR", "Bulgaria"},
+	{"BH", "BHR", "Bahrain"},
+	{"BI", "BDI", "Burundi"},
+	{"BJ", "BEN", "Benin"},
+	{"BL", "BLM", "Saint Barthélemy"},
+	{"BM", "BMU", "Bermuda"},
+	{"BN", "BRN", "Brunei Darussalam"},
+	{"BO", "BOL", "Bolivia"},
+	{"BQ", "BES", "Bonaire, Sint Eustatius and Saba"},
+	{"BR", "BRA", "Brazil"},
+	{"BS", "BHS", "Bahamas"},
+	{"BT", "BTN", "Bhutan"},
+	{"BV", "BVT", "Bouvet Island"},
+	{"BW", "BWA", "Botswana"},
+	{"BY", "BLR", "Belarus"},
+	{"BZ", "BLZ", "Belize"},
+	{"CA", "CAN", "Canada"},
+	{"CC", "CCK", "Cocos (Keeling) Islands"},
+	{"CD", "COD", "Congo, The Democratic Republic of the"},
+	{"CF", "CAF", "Central African Republic"},
+	{"CG", "COG", "Congo"},
+	{"CH", "CHE", "Switzerland"},
+	{"CI", "CIV", "Côte d'Ivoire"},
+	{"CK", "COK", "Cook Islands"},
+	{"CL", "CHL", "Chile"},
+	{"CM", "CMR", "Cameroon"},
+	{"CN", "CHN", "China"},
+	{"CO", "COL", "Colombia"},
+	{"CR", "CRI", "Costa Rica"},
+	{"CU", "CUB", "Cuba"},
+	{"CV", "CPV", "Cabo Verde"},
+	{"CW", "CUW", "Curaçao"},
+	{"CX", "CXR", "Christmas Island"},
+	{"CY", "CYP", "Cyprus"},
+	{"CZ", "CZE", "Czechia"},
+	{"DE", "DEU", "Germany"},
+	{"DJ", "DJI", "Djibouti"},
+	{"DK", "DNK", "Denmark"},
+	{"DM", "DMA", "Dominica"},
+	{"DO", "DOM", "Dominican Republic"},
+	{"DZ", "DZA", "Algeria"},
+	{"EC", "ECU", "Ecuador"},
+	{"EE", "EST", "Estonia"},
+	{"EG", "EGY", "Egypt"},
+	{"EH", "ESH", "Western Sahara"},
+	{"ER", "ERI", "Eritrea"},
+	{"ES", "ESP", "Spain"},
+	{"ET", "ETH", "Ethiopia"},
+	{"FI", "FIN", "Finland"},
+	{"FJ", "FJI", "Fiji"},
+	{"FK", "FLK", "Falkland Islands (Malvinas)"},
+	{"FM", "FSM", "Micronesia, Federated States of"},
+	{"FO", "FRO", "Faroe Islands"},
+	{"FR", "FRA", "France"},
+	{"GA", "GAB", "Gabon"},
+	{"GB", "GBR", "United Kingdom"},
+	{"GD", "GRD", "Grenada"},
+	{"GE", "GEO", "Georgia"},
+	{"GF", "GUF", "French Guiana"},
+	{"GG", "GGY", "Guernsey"},
+	{"GH", "GHA", "Ghana"},
+	{"GI", "GIB", "Gibraltar"},
+	{"GL", "GRL", "Greenland"},
+	{"GM", "GMB", "Gambia"},
+	{"GN", "GIN", "Guinea"},
+	{"GP", "GLP", "Guadeloupe"},
+	{"GQ", "GNQ", "Equatorial Guinea"},
+	{"GR", "GRC", "Greece"},
+	{"GS", "SGS", "South Georgia and the South Sandwich Islands"},
+	{"GT", "GTM", "Guatemala"},
+	{"GU", "GUM", "Guam"},
+	{"GW", "GNB", "Guinea-Bissau"},
+	{"GY", "GUY", "Guyana"},
+	{"HK", "HKG", "Hong Kong"},
+	{"HM", "HMD", "Heard Island and McDonald Islands"},
+	{"HN", "HND", "Honduras"},
+	{"HR", "HRV", "Croatia"},
+	{"HT", "HTI", "Haiti"},
+	{"HU", "HUN", "Hungary"},
+	{"ID", "IDN", "Indonesia"},
+	{"IE", "IRL", "Ireland"},
+	{"IL", "ISR", "Israel"},
+	{"IM", "IMN", "Isle of Man"},
+	{"IN", "IND", "India"},
+	{"IO", "IOT", "British Indian Ocean Territory"},
+	{"IQ", "IRQ", "Iraq"},
+	{"IR", "IRN", "Iran"},
+	{"IS", "ISL", "Iceland"},
+	{"IT", "ITA", "Italy"},
+	{"JE", "JEY", "Jersey"},
+	{"JM", "JAM", "Jamaica"},
+	{"JO", "JOR", "Jordan"},
+	{"JP", "JPN", "Japan"},
+	{"KE", "KEN", "Kenya"},
+	{"KG", "KGZ", "Kyrgyzstan"},
+	{"KH", "KHM", "Cambodia"},
+	{"KI", "KIR", "Kiribati"},
+	{"KM", "COM", "Comoros"},
+	{"KN", "KNA", "Saint Kitts and Nevis"},
+	{"KP", "PRK", "North Korea"},
+	{"KR", "KOR", "South Korea"},
+	{"KW", "KWT", "Kuwait"},
+	{"KY", "CYM", "Cayman Islands"},
+	{"KZ", "KAZ", "Kazakhstan"},
+	{"LA", "LAO", "Laos"},
+	{"LB", "LBN", "Lebanon"},
+	{"LC", "LCA", "Saint Lucia"},
+	{"LI", "LIE", "Liechtenstein"},
+	{"LK", "LKA", "Sri Lanka"},
+	{"LR", "LBR", "Liberia"},
+	{"LS", "LSO", "Lesotho"},
+	{"LT", "LTU", "Lithuania"},
+	{"LU", "LUX", "Luxembourg"},
+	{"LV", "LVA", "Latvia"},
+	{"LY", "LBY", "Libya"},
+	{"MA", "MAR", "Morocco"},
+	{"MC", "MCO", "Monaco"},
+	{"MD", "MDA", "Moldova"},
+	{"ME", "MNE", "Montenegro"},
+	{"MF", "MAF", "Saint Martin (French part)"},
+	{"MG", "MDG", "Madagascar"},
+	{"MH", "MHL", "Marshall Islands"},
+	{"MK", "MKD", "North Macedonia"},
+	{"ML", "MLI", "Mali"},
+	{"MM", "MMR", "Myanmar"},
+	{"MN", "MNG", "Mongolia"},
+	{"MO", "MAC", "Macao"},
+	{"MP", "MNP", "Northern Mariana Islands"},
+	{"MQ", "MTQ", "Martinique"},
+	{"MR", "MRT", "Mauritania"},
+	{"MS", "MSR", "Montserrat"},
+	{"MT", "MLT", "Malta"},
+	{"MU", "MUS", "Mauritius"},
+	{"MV", "MDV", "Maldives"},
+	{"MW", "MWI", "Malawi"},
+	{"MX", "MEX", "Mexico"},
+	{"MY", "MYS", "Malaysia"},
+	{"MZ", "MOZ", "Mozambique"},
+	{"NA", "NAM", "Namibia"},
+	{"NC", "NCL", "New Caledonia"},
+	{"NE", "NER", "Niger"},
+	{"NF", "NFK", "Norfolk Island"},
+	{"NG", "NGA", "Nigeria"},
+	{"NI", "NIC", "Nicaragua"},
+	{"NL", "NLD", "Netherlands"},
+	{"NO", "NOR", "Norway"},
+	{"NP", "NPL", "Nepal"},
+	{"NR", "NRU", "Nauru"},
+	{"NU", "NIU", "Niue"},
+	{"NZ", "NZL", "New Zealand"},
+	{"OM", "OMN", "Oman"},
+	{"PA", "PAN", "Panama"},
+	{"PE", "PER", "Peru"},
+	{"PF", "PYF", "French Polynesia"},
+	{"PG", "PNG", "Papua New Guinea"},
+	{"PH", "PHL", "Philippines"},
+	{"PK", "PAK", "Pakistan"},
+	{"PL", "POL", "Poland"},
+	{"PM", "SPM", "Saint Pierre and Miquelon"},
+	{"PN", "PCN", "Pitcairn"},
+	{"PR", "PRI", "Puerto Rico"},
+	{"PS", "PSE", "Palestine, State of"},
+	{"PT", "PRT", "Portugal"},
+	{"PW", "PLW", "Palau"},
+	{"PY", "PRY", "Paraguay"},
+	{"QA", "QAT", "Qatar"},
+	{"RE", "REU", "Réunion"},
+	{"RO", "ROU", "Romania"},
+	{"RS", "SRB", "Serbia"},
+	{"RU", "RUS", "Russian Federation"},
+	{"RW", "RWA", "Rwanda"},
+	{"SA", "SAU", "Saudi Arabia"},
+	{"SB", "SLB", "Solomon Islands"},
+	{"SC", "SYC", "Seychelles"},
+	{"SD", "SDN", "Sudan"},
+	{"SE", "SWE", "Sweden"},
+	{"SG", "SGP", "Singapore"},
+	{"SH", "SHN", "Saint Helena, Ascension and Tristan da Cunha"},
+	{"SI", "SVN", "Slovenia"},
+	{"SJ", "SJM", "Svalbard and Jan Mayen"},
+	{"SK", "SVK", "Slovakia"},
+	{"SL", "SLE", "Sierra Leone"},
+	{"SM", "SMR", "San Marino"},
+	{"SN", "SEN", "Senegal"},
+	{"SO", "SOM", "Somalia"},
+	{"SR", "SUR", "Suriname"},
+	{"SS", "SSD", "South Sudan"},
+	{"ST", "STP", "Sao Tome and Principe"},
+	{"SV", "SLV", "El Salvador"},
+	{"SX", "SXM", "Sint Maarten (Dutch part)"},
+	{"SY", "SYR", "Syria"},
+	{"SZ", "SWZ", "Eswatini"},
+	{"TC", "TCA", "Turks and Caicos Islands"},
+	{"TD", "TCD", "Chad"},
+	{"TF", "ATF", "French Southern Territories"},
+	{"TG", "TGO", "Togo"},
+	{"TH", "THA", "Thailand"},
+	{"TJ", "TJK", "Tajikistan"},
+	{"TK", "TKL", "Tokelau"},
+	{"TL", "TLS", "Timor-Leste"},
+	{"TM", "TKM", "Turkmenistan"},
+	{"TN", "TUN", "Tunisia"},
+	{"TO", "TON", "Tonga"},
+	{"TR", "TUR", "Türkiye"},
+	{"TT", "TTO", "Trinidad and Tobago"},
+	{"TV", "TUV", "Tuvalu"},
+	{"TW", "TWN", "Taiwan"},
+	{"TZ", "TZA", "Tanzania"},
+	{"UA", "UKR", "Ukraine"},
+	{"UG", "UGA", "Uganda"},
+	{"UM", "UMI", "United States Minor Outlying Islands"},
+	{"US", "USA", "United States"},
+	{"UY", "URY", "Uruguay"},
+	{"UZ", "UZB", "Uzbekistan"},
+	{"VA", "VAT", "Holy See (Vatican City State)"},
+	{"VC", "VCT", "Saint Vincent and the Grenadines"},
+	{"VE", "VEN", "Venezuela"},
+	{"VG", "VGB", "Virgin Islands, British"},
+	{"VI", "VIR", "Virgin Islands, U.S."},
+	{"VN", "VNM", "Vietnam"},
+	{"VU", "VUT", "Vanuatu"},
+	{"WF", "WLF", "Wallis and Futuna"},
+	{"WS", "WSM", "Samoa"},
+	{"YE", "YEM", "Yemen"},
+	{"YT", "MYT", "Mayotte"},
+	{"ZA", "ZAF", "South Africa"},
+	{"ZM", "ZMB", "Zambia"},
+	{"ZW", "ZWE", "Zimbabwe"},
+}
+
+// countryAliases are other names a query may use for a country, by ISO 3166 alpha-2 code.
+var countryAliases = map[string]string{
+	"Aland Islands":                    "AX",
+	"Bonaire":                          "BQ",
+	"Britain":                          "GB",
+	"British Virgin Islands":           "VG",
+	"Brunei":                           "BN",
+	"Burma":                            "MM",
+	"Cape Verde":                       "CV",
+	"Cote d'Ivoire":                    "CI",
+	"Curacao":                          "CW",
+	"Czech Republic":                   "CZ",
+	"Democratic Republic of the Congo": "CD",
+	"DR Congo":                         "CD",
+	"East Timor":                       "TL",
+	"England":                          "GB",
+	"Great Britain":                    "GB",
+	"Holland":                          "NL",
+	"Holy See":                         "VA",
+	"Ivory Coast":                      "CI",
+	"Lao People's Democratic Republic": "LA",
+	"Macedonia":                        "MK",
+	"Micronesia":                       "FM",
+	"Northern Ireland":                 "GB",
+	"Palestine":                        "PS",
+	"Republic of the Congo":            "CG",
+	"Reunion":                          "RE",
+	"Russia":                           "RU",
+	"Saint Barthelemy":                 "BL",
+	"Saint Helena":                     "SH",
+	"Scotland":                         "GB",
+	"South Korea":                      "KR",
+	"Swaziland":                        "SZ",
+	"Syrian Arab Republic":             "SY",
+	"Turkey":                           "TR",
+	"UAE":                              "AE",
+	"UK":                               "GB",
+	"United States of America":         "US",
+	"US Virgin Islands":                "VI",
+	"Vatican":                          "VA",
+	"Vatican City":                     "VA",
+	"Viet Nam":                         "VN",
+	"Wales":                            "GB",
+}
+
+var (
+	countriesByCode = map[string]Country{}
+	countriesByName = map[string]Country{}
+)
+
+func init() {
+	for _, c := range countries {
+		countriesByCode[c.Alpha2] = c
+		countriesByCode[c.Alpha3] = c
+		countriesByName[countryKey(c.Name)] = c
+	}
+	for name, code := range countryAliases {
+		countriesByName[countryKey(name)] = countriesByCode[code]
+	}
+}
+
+// CountryByCode returns the country with the given ISO 3166 alpha-2 or alpha-3 code, in any case.
+func CountryByCode(code string) (Country, bool) {
+	c, ok := countriesByCode[strings.ToUpper(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// CountryByName returns the country a query names, by its code, its name or a common alias such as "UK".
+// Case, spacing, periods and a leading "the" are ignored, so "the Netherlands" and "U.S.A." are both recognised.
+func CountryByName(name string) (Country, bool) {
+	key := countryKey(name)
+	if c, ok := CountryByCode(key); ok {
+		return c, true
+	}
+	c, ok := countriesByName[key]
+	return c, ok
+}
+
+func countryKey(name string) string {
+	key := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(name, ".", "")), " "))
+	return strings.TrimPrefix(key, "the ")
+}
+
+// QualifyName prepares a place name for the OpenWeather direct endpoint, which reads it as
+// "<city>,<state>,<country>", the state only for the US and the country as an ISO 3166 code.
+// A name already ending in a country, by code or by name, has it replaced with its alpha-2 code, so
+// "Paris, France" is sent as "Paris, FR". Any other name of one or two parts is qualified with defaultCountry,
+// an alpha-2 code, unless it is empty. With the US as the default, a last part that is also a US state,
+// such as "VA" or "Georgia", is read as the state. US states are sent by their code, so "Richmond, Virginia"
+// is sent as "Richmond, VA, US". A name without commas is sent whole, see QualifyNameWithState.
+func QualifyName(name, defaultCountry string) string {
+	parts, country := splitCountry(name, defaultCountry)
+	if country == "" && len(parts) >= 3 {
+		return name // the API only reads three parts, there is no room for a country
+	}
+	if country == "" {
+		country = defaultCountry
+	}
+
+	if country == "US" {
+		parts = withUSStateCode(parts)
+	}
+	if country != "" {
+		parts = append(parts, country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// QualifyNameWithState qualifies a US place name without commas as QualifyName does, once the state ending it is
+// split off, so "richmond va" is "richmond, VA, US". It is false for any other name, and for a name not ending in
+// a state. Names such as "Fort Washington" also end in one, so this is only for names that matched nothing as given.
+func QualifyNameWithState(name, defaultCountry string) (string, bool) {
+	parts, country := splitCountry(name, defaultCountry)
+	if country == "" {
+		country = defaultCountry
+	}
+	if country != "US" {
+		return "", false
+	}
+	parts, ok := splitUSState(parts)
+	if !ok {
+		return "", false
+	}
+	return strings.Join(append(parts, country), ", "), true
+}
+
+// searchCountry splits a place name as splitCountry does, for providers that search within the country it returns:
+// the country the name ends in, or else defaultCountry. A last part that is also a US state, such as "IL", "CO" or
+// "VA", is not read as Israel, Colombia or the Vatican. It is kept with the other parts, and the name is searched
+// in the US when that is the default, or in every country otherwise.
+func searchCountry(name, defaultCountry string) ([]string, string) {
+	all := strings.Split(name, ",")
+	parts, country := splitCountry(name, defaultCountry)
+	if last := strings.TrimSpace(all[len(all)-1]); country != "" && isUSState(last) {
+		parts, country = append(parts, last), ""
+		if defaultCountry != "US" {
+			return parts, ""
+		}
+	}
+	if country == "" {
+		country = defaultCountry
+	}
+	return parts, country
+}
+
+// splitCountry splits a place name on commas and takes off the country it ends in, by code or by name,
+// returning the remaining parts and the alpha-2 code of the country, empty when the name does not end in one.
+// With the US as defaultCountry, a second part that is also a US state, such as "VA" or "Georgia", is read as the state.
+func splitCountry(name, defaultCountry string) ([]string, string) {
+	parts := strings.Split(name, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) < 2 {
+		return parts, ""
+	}
+
+	last := parts[len(parts)-1]
+	if len(parts) == 2 && defaultCountry == "US" && isUSState(last) {
+		return parts, ""
+	}
+	if c, ok := CountryByName(last); ok {
+		return parts[:len(parts)-1], c.Alpha2
+	}
+	return parts, ""
+}
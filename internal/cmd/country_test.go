@@ -0,0 +1,153 @@
+package cmd_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"testing"
+)
+
+func TestCountryByName(t *testing.T) {
+	tests := map[string]struct {
+		name           string
+		expectedAlpha2 string
+	}{
+		"alpha-2 code":         {name: "fr", expectedAlpha2: "FR"},
+		"alpha-3 code":         {name: "DEU", expectedAlpha2: "DE"},
+		"name":                 {name: "France", expectedAlpha2: "FR"},
+		"name in any case":     {name: "  new   ZEALAND ", expectedAlpha2: "NZ"},
+		"name with 'the'":      {name: "The Netherlands", expectedAlpha2: "NL"},
+		"code with periods":    {name: "U.S.A.", expectedAlpha2: "US"},
+		"alias":                {name: "UK", expectedAlpha2: "GB"},
+		"unaccented name":      {name: "Cote d'Ivoire", expectedAlpha2: "CI"},
+		"unknown name":         {name: "Atlantis"},
+		"unknown code":         {name: "XX"},
+		"part of a place name": {name: "Henrico"},
+		"empty":                {name: ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, ok := internalcmd.CountryByName(tc.name)
+			if ok != (tc.expectedAlpha2 != "") {
+				t.Fatalf("CountryByName() found a country: %t, expected %t", ok, !ok)
+			}
+			if c.Alpha2 != tc.expectedAlpha2 {
+				t.Errorf("CountryByName() = '%s', expected '%s'", c.Alpha2, tc.expectedAlpha2)
+			}
+		})
+	}
+}
+
+func TestQualifyName(t *testing.T) {
+	tests := map[string]struct {
+		name           string
+		defaultCountry string
+		expected       string
+	}{
+		"city": {
+			name: "Paris", defaultCountry: "US", expected: "Paris, US",
+		},
+		"city and state": {
+			name: "Henrico, VA", defaultCountry: "US", expected: "Henrico, VA, US",
+		},
+		"city and state name": {
+			name: "Richmond, Virginia", defaultCountry: "US", expected: "Richmond, VA, US",
+		},
+		"city and state without a comma is sent whole": {
+			name: "richmond va", defaultCountry: "US", expected: "richmond va, US",
+		},
+		"place ending in a state name": {
+			name: "Fort Washington", defaultCountry: "US", expected: "Fort Washington, US",
+		},
+		"another place ending in a state name": {
+			name: "Port Washington", defaultCountry: "US", expected: "Port Washington, US",
+		},
+		"place ending in a state name of several words": {
+			name: "East New York", defaultCountry: "US", expected: "East New York, US",
+		},
+		"state on its own": {
+			name: "West Virginia", defaultCountry: "US", expected: "West Virginia, US",
+		},
+		"state names are only read in the US": {
+			name: "Tbilisi Georgia", expected: "Tbilisi Georgia",
+		},
+		"city and state name shared with a country": {
+			name: "Atlanta, Georgia", defaultCountry: "US", expected: "Atlanta, GA, US",
+		},
+		"city and country name": {
+			name: "Paris, France", defaultCountry: "US", expected: "Paris, FR",
+		},
+		"city and alpha-3 country": {
+			name: "Berlin,DEU", defaultCountry: "US", expected: "Berlin, DE",
+		},
+		"city and state code shared with a country outside the US": {
+			name: "Ottawa, CA", defaultCountry: "FR", expected: "Ottawa, CA",
+		},
+		"city, state and country": {
+			name: "Richmond, Virginia, USA", defaultCountry: "GB", expected: "Richmond, VA, US",
+		},
+		"three parts are left alone": {
+			name: "Springfield, Sangamon County, Illinois", defaultCountry: "US", expected: "Springfield, Sangamon County, Illinois",
+		},
+		"another default country": {
+			name: "Toulouse", defaultCountry: "FR", expected: "Toulouse, FR",
+		},
+		"no default country": {
+			name: "Henrico, VA", expected: "Henrico, VA",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, internalcmd.QualifyName(tc.name, tc.defaultCountry)); diff != "" {
+				t.Errorf("QualifyName() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQualifyNameWithState(t *testing.T) {
+	tests := map[string]struct {
+		name           string
+		defaultCountry string
+		expected       string
+		expectedOk     bool
+	}{
+		"city and state": {
+			name: "richmond va", defaultCountry: "US", expected: "richmond, VA, US", expectedOk: true,
+		},
+		"city and state name of several words": {
+			name: "Charleston West Virginia", defaultCountry: "US", expected: "Charleston, WV, US", expectedOk: true,
+		},
+		"place ending in a state name": {
+			name: "Fort Washington", defaultCountry: "US", expected: "Fort, WA, US", expectedOk: true,
+		},
+		"city and state with a US country": {
+			name: "richmond virginia, USA", expected: "richmond, VA, US", expectedOk: true,
+		},
+		"state on its own": {
+			name: "West Virginia", defaultCountry: "US",
+		},
+		"no state": {
+			name: "Fallafelville", defaultCountry: "US",
+		},
+		"already split on commas": {
+			name: "Richmond, VA", defaultCountry: "US",
+		},
+		"state names are only read in the US": {
+			name: "Tbilisi Georgia", defaultCountry: "FR",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			qualified, ok := internalcmd.QualifyNameWithState(tc.name, tc.defaultCountry)
+			if ok != tc.expectedOk {
+				t.Fatalf("QualifyNameWithState() Unexpected ok: %t, expected %t", ok, tc.expectedOk)
+			}
+			if diff := cmp.Diff(tc.expected, qualified); diff != "" {
+				t.Errorf("QualifyNameWithState() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Lookups fail with an *APIError wrapping one of these, so callers can tell failures apart with errors.Is.
+var (
+	ErrUnauthorized = errors.New("invalid API key") // the provider refused the request, with a 401 or 403
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUpstream     = errors.New("unexpected response")
+)
+
+// APIError is an unsuccessful response from the API. Use errors.As to read the response itself.
+type APIError struct {
+	StatusCode int
+	Body       []byte
+	Err        error // ErrUnauthorized, ErrNotFound, ErrRateLimited or ErrUpstream
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s (status code %d)", e.Err, e.StatusCode)
+	if detail := e.message(); detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, detail)
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// message returns the explanation the API gives in the body of an error, if there is one,
+// such as the message of OpenWeather or the errors of the Census geocoder.
+func (e *APIError) message() string {
+	var body struct {
+		Message string   `json:"message"`
+		Errors  []string `json:"errors"`
+	}
+	if json.Unmarshal(e.Body, &body) != nil {
+		return ""
+	}
+	if body.Message == "" {
+		return strings.Join(body.Errors, "; ")
+	}
+	return body.Message
+}
+
+// checkStatus returns nil for a successful status code, or the *APIError describing the failure.
+func checkStatus(statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+
+	err := ErrUpstream
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err = ErrUnauthorized
+	case http.StatusNotFound:
+		err = ErrNotFound
+	case http.StatusTooManyRequests:
+		err = ErrRateLimited
+	}
+	return &APIError{StatusCode: statusCode, Body: body, Err: err}
+}
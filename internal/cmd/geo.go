@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type ZipResult struct {
@@ -18,109 +23,286 @@ type ZipResult struct {
 }
 
 type NameResult struct {
-	Name    string  `json:"name"`
-	Lat     float64 `json:"lat"`
-	Lon     float64 `json:"lon"`
-	Country string  `json:"country"`
-	State   string  `json:"state"`
+	Name       string            `json:"name"`
+	LocalNames map[string]string `json:"local_names,omitempty"` // Name by ISO 639-1 language, when OpenWeather knows it
+	Lat        float64           `json:"lat"`
+	Lon        float64           `json:"lon"`
+	Country    string            `json:"country"`
+	State      string            `json:"state"`
+}
+
+// LocalName returns the name of the place in lang, an ISO 639-1 code such as "es", falling back to Name.
+// A regional language, such as "fr-CA", falls back to its base language first.
+func (r NameResult) LocalName(lang string) string {
+	lang = strings.ToLower(lang)
+	if name, ok := r.LocalNames[lang]; ok && name != "" {
+		return name
+	}
+	if base, _, found := strings.Cut(strings.ReplaceAll(lang, "_", "-"), "-"); found {
+		if name, ok := r.LocalNames[base]; ok && name != "" {
+			return name
+		}
+	}
+	return r.Name
 }
 
+// DefaultBaseURL is the OpenWeather API root used when DirectGeocoding.BaseURL is empty.
+const DefaultBaseURL = "https://api.openweathermap.org"
+
+// DirectGeocoding looks places up with the OpenWeather geocoding API. Names are no longer assumed to be in the USA:
+// without a Country they are looked up worldwide, where "Richmond, VA" may be read as the Vatican, so set it to "US".
 type DirectGeocoding struct {
-	Key string // OpenWeather API Key
+	Key     string       // OpenWeather API Key
+	BaseURL string       // API root, such as a proxy or local stub, DefaultBaseURL when empty
+	Country string       // ISO 3166 alpha-2 country of names and postal codes that do not name one, such as "US", none when empty
+	Limit   int          // Most places a name lookup returns, up to 5, left to the API when 0
+	Lang    string       // ISO 639-1 language of the place names Resolve returns, the API's own names when empty
+	Client  *http.Client // Client used for API calls, http.DefaultClient when nil
+	Limiter *RateLimiter // Paces every API call, unlimited when nil
+	Retry   *RetryPolicy // Retries transient failures, never retries when nil
 }
 
-// LocationByName returns the coordinates of a named location.
+// LocationByName returns the coordinates of a named location, or none when the name is not recognised.
+// The name is qualified with Country unless it already names a country, see QualifyName. A US name without commas
+// that matches nothing, such as "richmond va", is looked up once more with its state split off, see QualifyNameWithState.
 // see https://openweathermap.org/api/geocoding-api#direct_name
-func (g *DirectGeocoding) LocationByName(name string) ([]NameResult, int, error) {
-	uri := g.buildNameLookupUri(name)
+func (g *DirectGeocoding) LocationByName(name string) ([]NameResult, error) {
+	return g.LocationByNameContext(context.Background(), name)
+}
 
-	result, err := http.Get(uri)
+// LocationByNameContext is LocationByName, bounded by ctx.
+func (g *DirectGeocoding) LocationByNameContext(ctx context.Context, name string) ([]NameResult, error) {
+	results, err := g.lookupName(ctx, QualifyName(name, g.Country))
+	if err != nil || len(results) > 0 {
+		return results, err
+	}
+	if qualified, ok := QualifyNameWithState(name, g.Country); ok {
+		return g.lookupName(ctx, qualified)
+	}
+	return results, nil
+}
 
-	var statusCode = 0
-	if result != nil {
-		statusCode = result.StatusCode
+// lookupName looks up a name already qualified for the API.
+func (g *DirectGeocoding) lookupName(ctx context.Context, qualified string) ([]NameResult, error) {
+	uri, err := g.buildNameLookupUri(qualified)
+	if err != nil {
+		return nil, err
 	}
+	return g.lookupNames(ctx, uri)
+}
 
-	if err != nil || result == nil || statusCode < 200 || statusCode >= 300 {
-		return nil, statusCode, err
+// LocationByZip returns the coordinates of a zip or postal code in the given ISO 3166 alpha-2 country,
+// failing with ErrNotFound when it is not recognised. An empty country leaves it up to the API, which assumes the US.
+// see https://openweathermap.org/api/geocoding-api#direct_zip
+func (g *DirectGeocoding) LocationByZip(zip, country string) (*ZipResult, error) {
+	return g.LocationByZipContext(context.Background(), zip, country)
+}
+
+// LocationByZipContext is LocationByZip, bounded by ctx.
+func (g *DirectGeocoding) LocationByZipContext(ctx context.Context, zip, country string) (*ZipResult, error) {
+	postal := PostalCode{Code: zip, Country: country}
+	uri, err := g.buildZipLookupUri(postal)
+	if err != nil {
+		return nil, err
 	}
 
-	resultBody, err := io.ReadAll(result.Body)
+	resultBody, err := g.get(ctx, uri)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("zip '%s' %w", postal, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	location := &ZipResult{}
+	err = json.Unmarshal(resultBody, location)
 	if err != nil {
-		return nil, statusCode, err
+		return nil, err
+	}
+
+	if location.Zip == "" {
+		return nil, fmt.Errorf("zip '%s' %w", postal, ErrNotFound)
+	}
+
+	return location, nil
+}
+
+// LocationByCoordinates returns the named locations nearest to the given coordinates.
+// A limit of 0 leaves the number of results up to the API.
+// see https://openweathermap.org/api/geocoding-api#reverse
+func (g *DirectGeocoding) LocationByCoordinates(lat, lon float64, limit int) ([]NameResult, error) {
+	return g.LocationByCoordinatesContext(context.Background(), lat, lon, limit)
+}
+
+// LocationByCoordinatesContext is LocationByCoordinates, bounded by ctx.
+func (g *DirectGeocoding) LocationByCoordinatesContext(ctx context.Context, lat, lon float64, limit int) ([]NameResult, error) {
+	uri, err := g.buildReverseLookupUri(lat, lon, limit)
+	if err != nil {
+		return nil, err
+	}
+	return g.lookupNames(ctx, uri)
+}
+
+// lookupNames fetches a list of named locations, as returned by the direct and reverse endpoints.
+func (g *DirectGeocoding) lookupNames(ctx context.Context, uri string) ([]NameResult, error) {
+	resultBody, err := g.get(ctx, uri)
+	if err != nil {
+		return nil, err
 	}
 
 	var locations []NameResult
 	err = json.Unmarshal(resultBody, &locations)
 	if err != nil {
-		return nil, statusCode, err
+		return nil, err
 	}
 
-	return locations, statusCode, nil
+	return locations, nil
+}
+
+// get performs a GET against the API and returns the body of a successful response,
+// retrying transient failures as the Retry policy allows. An unsuccessful response is an *APIError.
+func (g *DirectGeocoding) get(ctx context.Context, uri string) ([]byte, error) {
+	return httpGet(ctx, g.Client, g.Limiter, g.Retry, uri, nil)
+}
+
+// httpGet performs a GET against a provider's API and returns the body of a successful response, pacing every
+// attempt with limiter and retrying transient failures as retry allows. An unsuccessful response is an *APIError.
+func httpGet(ctx context.Context, client *http.Client, limiter pacer, retry *RetryPolicy, uri string, header http.Header) ([]byte, error) {
+	return httpDo(ctx, client, limiter, retry, http.MethodGet, uri, header, nil)
 }
 
-func (g *DirectGeocoding) LocationByZip(zip string) (*ZipResult, int, error) {
-	uri := g.buildZipLookupUri(zip)
+// httpDo is httpGet for any method, sending body with every attempt. With a retry policy, the request must be
+// idempotent, see RetryPolicy.
+func httpDo(ctx context.Context, client *http.Client, limiter pacer, retry *RetryPolicy, method, uri string, header http.Header, body []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		resultBody, statusCode, retryAfter, err := httpDoOnce(ctx, client, limiter, method, uri, header, body)
 
-	result, err := http.Get(uri)
-	var statusCode = 0
-	if result != nil {
-		statusCode = result.StatusCode
+		delay, again := retry.retryDelay(ctx, attempt, statusCode, retryAfter, err)
+		if !again {
+			if err == nil {
+				err = checkStatus(statusCode, resultBody)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return resultBody, nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
+}
 
-	if err != nil || result == nil {
-		return nil, statusCode, err
+// httpDoOnce performs a single request, returning the Retry-After delay the API asked for, if any.
+func httpDoOnce(ctx context.Context, client *http.Client, limiter pacer, method, uri string, header http.Header, body []byte) ([]byte, int, time.Duration, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, reqBody)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
+	if limiter != nil {
+		if err = limiter.Wait(ctx); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	result, err := client.Do(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer result.Body.Close()
+
+	retryAfter := parseRetryAfter(result.Header.Get("Retry-After"))
+
 	resultBody, err := io.ReadAll(result.Body)
 	if err != nil {
-		return nil, statusCode, err
+		return nil, result.StatusCode, retryAfter, err
 	}
 
-	location := &ZipResult{}
-	err = json.Unmarshal(resultBody, location)
+	return resultBody, result.StatusCode, retryAfter, nil
+}
+
+// apiUrl resolves an API path against the configured base URL.
+func (g *DirectGeocoding) apiUrl(path string) (*url.URL, error) {
+	uri, err := baseURL(g.BaseURL, DefaultBaseURL)
 	if err != nil {
-		return nil, statusCode, err
+		return nil, err
 	}
+	return uri.JoinPath(path), nil
+}
 
-	if location.Zip == "" {
-		return nil, statusCode, fmt.Errorf("zip '%s' not found", zip)
+// baseURL parses the base URL of a provider, fallback when base is empty, failing unless it is http or https.
+func baseURL(base, fallback string) (*url.URL, error) {
+	if base == "" {
+		base = fallback
 	}
 
-	return location, statusCode, nil
+	uri, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API base URL '%s': %w", base, err)
+	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return nil, fmt.Errorf("invalid API base URL '%s': scheme must be 'http' or 'https'", base)
+	}
+	return uri, nil
 }
 
-func (g *DirectGeocoding) buildNameLookupUri(name string) string {
-	uri := url.URL{
-		Scheme: "http",
-		Host:   "api.openweathermap.org",
-		Path:   "geo/1.0/direct",
+func (g *DirectGeocoding) buildNameLookupUri(qualified string) (string, error) {
+	uri, err := g.apiUrl("geo/1.0/direct")
+	if err != nil {
+		return "", err
+	}
+
+	q := uri.Query()
+	q.Add("q", qualified)
+	if g.Limit > 0 {
+		q.Add("limit", strconv.Itoa(g.Limit))
 	}
+	q.Add("appid", g.Key)
 
-	// Default USA
-	if len(strings.Split(name, ",")) < 3 {
-		name = fmt.Sprintf("%s, USA", name)
+	uri.RawQuery = q.Encode()
+	return uri.String(), nil
+}
+
+func (g *DirectGeocoding) buildZipLookupUri(postal PostalCode) (string, error) {
+	uri, err := g.apiUrl("geo/1.0/zip")
+	if err != nil {
+		return "", err
 	}
 
 	q := uri.Query()
-	q.Add("q", name)
+	q.Add("zip", postal.String())
 	q.Add("appid", g.Key)
 
 	uri.RawQuery = q.Encode()
-	return uri.String()
+	return uri.String(), nil
 }
 
-func (g *DirectGeocoding) buildZipLookupUri(zip string) string {
-	uri := url.URL{
-		Scheme: "http",
-		Host:   "api.openweathermap.org",
-		Path:   "geo/1.0/zip",
+func (g *DirectGeocoding) buildReverseLookupUri(lat, lon float64, limit int) (string, error) {
+	uri, err := g.apiUrl("geo/1.0/reverse")
+	if err != nil {
+		return "", err
 	}
 
 	q := uri.Query()
-	q.Add("zip", zip)
+	q.Add("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	q.Add("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
 	q.Add("appid", g.Key)
 
 	uri.RawQuery = q.Encode()
-	return uri.String()
+	return uri.String(), nil
 }
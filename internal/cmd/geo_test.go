@@ -3,10 +3,11 @@
 package cmd_test
 
 import (
+	"errors"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/squeedee/geo/cmd"
 	internalcmd "github.com/squeedee/geo/internal/cmd"
-	"net/http"
 	"os"
 	"testing"
 )
@@ -19,15 +20,15 @@ func TestLocationByName(t *testing.T) {
 	}
 
 	defaultFields := internalcmd.DirectGeocoding{
-		Key: ApiKey,
+		Key:     ApiKey,
+		Country: "US",
 	}
 
 	tests := map[string]struct {
 		name             string
 		fields           internalcmd.DirectGeocoding
-		expectedError    string
+		expectedErr      error
 		expectedLocation []internalcmd.NameResult
-		expectStatusCode int
 	}{
 		"Richmond, well formed without country code is successfully found": {
 			fields: defaultFields,
@@ -41,7 +42,6 @@ func TestLocationByName(t *testing.T) {
 					State:   "Virginia",
 				},
 			},
-			expectStatusCode: http.StatusOK,
 		},
 		"Richmond, well formed with country code is successfully found": {
 			fields: defaultFields,
@@ -55,36 +55,26 @@ func TestLocationByName(t *testing.T) {
 					State:   "Virginia",
 				},
 			},
-			expectStatusCode: http.StatusOK,
 		},
 		"Unknown place is an empty location list": { // Note: The OpenWeather API is really inconsistent
 			fields:           defaultFields,
 			name:             "Fallafelville",
 			expectedLocation: []internalcmd.NameResult{},
-			expectStatusCode: http.StatusOK,
 		},
 		"Known place with bad api key is unauthorized": {
 			fields: internalcmd.DirectGeocoding{
 				Key: "invalid-key",
 			},
-			name:             "Richmond, VA, USA",
-			expectStatusCode: http.StatusUnauthorized,
+			name:        "Richmond, VA, USA",
+			expectedErr: internalcmd.ErrUnauthorized,
 		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			location, code, err := tc.fields.LocationByName(tc.name)
-			if tc.expectedError == "" && err != nil {
-				t.Fatalf("LocationByName() Unexpected error: %v", err)
-			} else if tc.expectedError != "" && err == nil {
-				t.Fatalf("LocationByName() Expected error, but didn't get one")
-			} else if err != nil && tc.expectedError != err.Error() {
-				t.Fatalf("LocationByName() Unexpected error: %s, expected %s", err, tc.expectedError)
-			}
-
-			if tc.expectStatusCode != code {
-				t.Fatalf("LocationByName() Unexpected status code: %d, expected %d", code, tc.expectStatusCode)
+			location, err := tc.fields.LocationByName(tc.name)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("LocationByName() Unexpected error: %v, expected %v", err, tc.expectedErr)
 			}
 
 			if diff := cmp.Diff(tc.expectedLocation, location); diff != "" {
@@ -100,16 +90,15 @@ func TestLocationByZip(t *testing.T) {
 	}
 
 	defaultFields := internalcmd.DirectGeocoding{
-		Key: ApiKey,
+		Key:     ApiKey,
+		Country: "US",
 	}
 
 	tests := map[string]struct {
 		zip              string
 		fields           internalcmd.DirectGeocoding
-		expectedError    string
+		expectedErr      error
 		expectedLocation *internalcmd.ZipResult
-		expectErrorKind  string
-		expectStatusCode int
 	}{
 		"23228 is valid": {
 			zip:    "23228",
@@ -121,43 +110,31 @@ func TestLocationByZip(t *testing.T) {
 				Lon:     -77.398,
 				Country: "US",
 			},
-			expectStatusCode: http.StatusOK,
 		},
 		"99999 is a 404 error": {
-			fields:           defaultFields,
-			zip:              "99999",
-			expectedError:    "zip '99999' not found",
-			expectStatusCode: http.StatusNotFound,
+			fields:      defaultFields,
+			zip:         "99999",
+			expectedErr: internalcmd.ErrNotFound,
 		},
 		"99998 is a 404 error": { // variation check
-			fields:           defaultFields,
-			zip:              "99998",
-			expectedError:    "zip '99998' not found",
-			expectStatusCode: http.StatusNotFound,
+			fields:      defaultFields,
+			zip:         "99998",
+			expectedErr: internalcmd.ErrNotFound,
 		},
-		"invalid api key provides unauthorized code": { // variation check
+		"invalid api key is unauthorized": { // variation check
 			fields: internalcmd.DirectGeocoding{
 				Key: "invalid-key",
 			},
-			zip:              "23228",
-			expectStatusCode: http.StatusUnauthorized,
-			expectedError:    "zip '23228' not found",
+			zip:         "23228",
+			expectedErr: internalcmd.ErrUnauthorized,
 		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			location, code, err := tc.fields.LocationByZip(tc.zip)
-			if tc.expectedError == "" && err != nil {
-				t.Fatalf("LocationByZip() Unexpected error: %v", err)
-			} else if tc.expectedError != "" && err == nil {
-				t.Fatalf("LocationByZip() Expected error, but didn't get one")
-			} else if err != nil && tc.expectedError != err.Error() {
-				t.Fatalf("LocationByZip() Unexpected error: %s, expected %s", err, tc.expectedError)
-			}
-
-			if tc.expectStatusCode != code {
-				t.Fatalf("LocationByZip() Unexpected status code: %d, expected %d", code, tc.expectStatusCode)
+			location, err := tc.fields.LocationByZip(tc.zip, "")
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("LocationByZip() Unexpected error: %v, expected %v", err, tc.expectedErr)
 			}
 
 			if diff := cmp.Diff(tc.expectedLocation, location); diff != "" {
@@ -166,3 +143,59 @@ func TestLocationByZip(t *testing.T) {
 		})
 	}
 }
+
+func TestLocationByCoordinates(t *testing.T) {
+	if ApiKey == "" {
+		t.Fatalf("No %s set", cmd.ApiKeyName)
+	}
+
+	defaultFields := internalcmd.DirectGeocoding{
+		Key:     ApiKey,
+		Country: "US",
+	}
+
+	tests := map[string]struct {
+		lat, lon         float64
+		limit            int
+		fields           internalcmd.DirectGeocoding
+		expectedErr      error
+		expectedLocation []internalcmd.NameResult
+	}{
+		"Richmond city coordinates are Richmond, Virginia": {
+			fields: defaultFields,
+			lat:    37.5385087,
+			lon:    -77.43428,
+			limit:  1,
+			expectedLocation: []internalcmd.NameResult{
+				{
+					Name:    "Richmond",
+					Country: "US",
+					State:   "Virginia",
+				},
+			},
+		},
+		"Known coordinates with bad api key is unauthorized": {
+			fields: internalcmd.DirectGeocoding{
+				Key: "invalid-key",
+			},
+			lat:         37.5385087,
+			lon:         -77.43428,
+			limit:       1,
+			expectedErr: internalcmd.ErrUnauthorized,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			location, err := tc.fields.LocationByCoordinates(tc.lat, tc.lon, tc.limit)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("LocationByCoordinates() Unexpected error: %v, expected %v", err, tc.expectedErr)
+			}
+
+			// The nearest named point drifts a little between API releases, so only the place is compared.
+			if diff := cmp.Diff(tc.expectedLocation, location, cmpopts.IgnoreFields(internalcmd.NameResult{}, "Lat", "Lon")); diff != "" {
+				t.Errorf("LocationByCoordinates() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
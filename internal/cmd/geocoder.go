@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+)
+
+// Geocoder finds places by name, by postal code or by coordinates, whichever provider is behind it,
+// such as DirectGeocoding for OpenWeather.
+type Geocoder interface {
+	// Search returns the places a name matches, best match first, or none when the name is not recognised.
+	Search(ctx context.Context, name string) ([]Place, error)
+	// SearchPostalCode returns the place of a postal code, or none when the code is not recognised.
+	SearchPostalCode(ctx context.Context, postal PostalCode) ([]Place, error)
+	// Reverse returns the places nearest to the coordinates, nearest first. A limit of 0 leaves the number up to the provider.
+	Reverse(ctx context.Context, lat, lon float64, limit int) ([]Place, error)
+}
+
+// BatchGeocoder is a Geocoder that can also search for many names in a few requests, such as Census.
+type BatchGeocoder interface {
+	Geocoder
+	// SearchBatch returns the places each name matches, in the order of names, as Search would.
+	SearchBatch(ctx context.Context, names []string) ([][]Place, error)
+}
+
+// Place is a single match of a Geocoder.
+type Place struct {
+	Name      string
+	State     string
+	Country   string // ISO 3166 alpha-2 code
+	Zip       string // Postal code, when the place was found by one or is a street address
+	Lat       float64
+	Lon       float64
+	BBox      []float64 // West, south, east and north bounds, as in GeoJSON, when the provider reports them
+	OSMType   string    // OpenStreetMap element type, "node", "way" or "relation", for places found in OpenStreetMap
+	OSMID     int64     // OpenStreetMap element id, for places found in OpenStreetMap
+	TractFIPS string    // 11 digit FIPS code of the census tract, for places found by the Census geocoder
+	BlockFIPS string    // 15 digit FIPS code of the census block, for places found by the Census geocoder
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// geojsonFeature is an RFC 7946 Point Feature for one record.
+// see https://datatracker.ietf.org/doc/html/rfc7946#section-3.2
+type geojsonFeature struct {
+	Type       string            `json:"type"`
+	BBox       []float64         `json:"bbox,omitempty"` // the extent of the place, where the provider reports one
+	Geometry   geojsonPoint      `json:"geometry"`
+	Properties geojsonProperties `json:"properties"`
+}
+
+type geojsonPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude first, as RFC 7946 requires
+}
+
+type geojsonProperties struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	StateCode string     `json:"state_code"`
+	Country   string     `json:"country"`
+	Zip       string     `json:"zip"`
+	OSMType   string     `json:"osm_type,omitempty"`
+	OSMID     int64      `json:"osm_id,omitempty"`
+	TractFIPS string     `json:"tract_fips,omitempty"`
+	BlockFIPS string     `json:"block_fips,omitempty"`
+	Relaxed   string     `json:"relaxed,omitempty"`
+	Precision Precision  `json:"precision,omitempty"`
+	Query     string     `json:"query"`
+	Type      LookupType `json:"type"`
+}
+
+// geojsonFormat writes a single FeatureCollection, with one Point Feature per record.
+type geojsonFormat struct {
+	w       io.Writer
+	written int
+}
+
+func (f *geojsonFormat) WriteLookup(lookup Lookup) error {
+	for _, r := range lookup.Records {
+		b, err := json.Marshal(geojsonFeature{
+			Type: "Feature",
+			BBox: r.BBox,
+			Geometry: geojsonPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{r.Lon, r.Lat},
+			},
+			Properties: geojsonProperties{
+				Name:      r.Name,
+				State:     r.State,
+				StateCode: r.StateCode,
+				Country:   r.Country,
+				Zip:       r.Zip,
+				OSMType:   r.OSMType,
+				OSMID:     r.OSMID,
+				TractFIPS: r.TractFIPS,
+				BlockFIPS: r.BlockFIPS,
+				Relaxed:   r.Relaxed,
+				Precision: r.Precision,
+				Query:     r.Query,
+				Type:      r.Type,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		sep := ",\n"
+		if f.written == 0 {
+			sep = "{\"type\":\"FeatureCollection\",\"features\":[\n"
+		}
+		if _, err = fmt.Fprintf(f.w, "%s%s", sep, b); err != nil {
+			return err
+		}
+		f.written++
+	}
+	return nil
+}
+
+func (f *geojsonFormat) Close() error {
+	if f.written == 0 {
+		_, err := fmt.Fprintln(f.w, "{\"type\":\"FeatureCollection\",\"features\":[]}")
+		return err
+	}
+	_, err := fmt.Fprintln(f.w, "\n]}")
+	return err
+}
@@ -0,0 +1,405 @@
+package cmd
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GeoNamesFileName is the index database kept in the data directory.
+const GeoNamesFileName = "geonames.db"
+
+// The index keeps each kind of GeoNames record in its own bucket, along with the keys places are found by.
+var (
+	placesBucket  = []byte("places")  // geonameid → geoNamesPlace
+	namesBucket   = []byte("names")   // normalized name, 0, geonameid → nothing
+	cellsBucket   = []byte("cells")   // grid cell, geonameid → nothing, see cellKey
+	postalBucket  = []byte("postal")  // normalized code, 0, country, 0, place name → geoNamesPostal
+	regionsBucket = []byte("regions") // country, ".", admin1 code → admin1 name, tab, ASCII name
+)
+
+// geoNamesImportBatch is the number of records written by each transaction of an import.
+const geoNamesImportBatch = 20000
+
+// GeoNamesIndex is a bbolt database of the populated places and postal codes of GeoNames dumps, so places can
+// be looked up without a network. Build it with Import, then look places up with Offline.
+// see https://download.geonames.org/export/dump/readme.txt
+type GeoNamesIndex struct {
+	db *bbolt.DB
+}
+
+// GeoNamesImport counts the records an Import added to the index, by kind.
+type GeoNamesImport struct {
+	Places      int // populated places, from allCountries.txt, cities500.txt and the like
+	PostalCodes int // from the postal code dumps, such as allCountries.txt or US.txt of export/zip
+	Regions     int // names of first level administrative divisions, from admin1CodesASCII.txt
+}
+
+// geoNamesPlace is a populated place of the index.
+type geoNamesPlace struct {
+	Name       string  `json:"name"`
+	Country    string  `json:"country"`
+	Admin1     string  `json:"admin1"` // code of the first level administrative division, the state code in the US
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Population int64   `json:"population"`
+}
+
+// geoNamesPostal is a postal code of the index.
+type geoNamesPostal struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Country string  `json:"country"`
+	State   string  `json:"state"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+// DefaultGeoNamesPath returns the index database under $XDG_DATA_HOME/geo, falling back to ~/.local/share/geo
+// when XDG_DATA_HOME is not set. Unlike the cache, the index cannot be rebuilt without the dumps it came from.
+func DefaultGeoNamesPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "geo", GeoNamesFileName), nil
+}
+
+// OpenGeoNames opens the index database at path, creating it unless readOnly is set. An index opened to
+// read fails with an error wrapping os.ErrNotExist when nothing has been imported yet.
+func OpenGeoNames(path string, readOnly bool) (*GeoNamesIndex, error) {
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("index '%s' is in use by another process", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &GeoNamesIndex{db: db}, nil
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{placesBucket, namesBucket, cellsBucket, postalBucket, regionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &GeoNamesIndex{db: db}, nil
+}
+
+// Close releases the database file.
+func (x *GeoNamesIndex) Close() error {
+	return x.db.Close()
+}
+
+// Import adds the records of a GeoNames dump to the index, telling the kind of dump from its number of columns:
+// 19 for places, of which only populated places are kept, 12 for postal codes and 4 for region names.
+// Importing a dump again overwrites its records rather than adding them twice.
+func (x *GeoNamesIndex) Import(r io.Reader) (GeoNamesImport, error) {
+	var counts GeoNamesImport
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // alternate names make some lines very long
+
+	// Writes are synced once at the end, rather than by each batch, as an interrupted import is simply run again.
+	x.db.NoSync = true
+	defer func() { x.db.NoSync = false }()
+
+	tx, err := x.db.Begin(true)
+	if err != nil {
+		return counts, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	columns, pending := 0, 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if columns == 0 {
+			columns = len(fields)
+		}
+		if len(fields) != columns {
+			return counts, fmt.Errorf("line %d has %d columns, expected %d", line, len(fields), columns)
+		}
+
+		switch columns {
+		case 19:
+			var added bool
+			if added, err = importGeoNamesPlace(tx, fields); added {
+				counts.Places++
+			}
+		case 12:
+			err = importGeoNamesPostal(tx, fields)
+			counts.PostalCodes++
+		case 4:
+			err = tx.Bucket(regionsBucket).Put([]byte(fields[0]), []byte(fields[1]+"\t"+fields[2]))
+			counts.Regions++
+		default:
+			return counts, fmt.Errorf("not a GeoNames dump, lines have %d columns, expected 19 for places, 12 for postal codes or 4 for regions", columns)
+		}
+		if err != nil {
+			return counts, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if pending++; pending == geoNamesImportBatch {
+			if err = tx.Commit(); err != nil {
+				return counts, err
+			}
+			if tx, err = x.db.Begin(true); err != nil {
+				return counts, err
+			}
+			pending = 0
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return counts, err
+	}
+	if err = tx.Commit(); err != nil {
+		return counts, err
+	}
+	return counts, x.db.Sync()
+}
+
+// importGeoNamesPlace stores a line of a places dump, whose columns are the geonameid, name, ASCII name,
+// alternate names, latitude, longitude, feature class and code, country, other countries, four administrative
+// codes, population, elevation, digital elevation, time zone and modification date.
+func importGeoNamesPlace(tx *bbolt.Tx, fields []string) (bool, error) {
+	if fields[6] != "P" {
+		return false, nil // not a populated place, such as a mountain or a lake
+	}
+
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return false, fmt.Errorf("invalid geonameid '%s'", fields[0])
+	}
+	place := geoNamesPlace{Name: fields[1], Country: fields[8], Admin1: fields[10]}
+	if place.Lat, err = strconv.ParseFloat(fields[4], 64); err != nil {
+		return false, fmt.Errorf("invalid latitude '%s'", fields[4])
+	}
+	if place.Lon, err = strconv.ParseFloat(fields[5], 64); err != nil {
+		return false, fmt.Errorf("invalid longitude '%s'", fields[5])
+	}
+	place.Population, _ = strconv.ParseInt(fields[14], 10, 64)
+
+	value, err := json.Marshal(place)
+	if err != nil {
+		return false, err
+	}
+	key := binary.BigEndian.AppendUint32(nil, uint32(id))
+	if err = tx.Bucket(placesBucket).Put(key, value); err != nil {
+		return false, err
+	}
+
+	for _, name := range []string{fields[1], fields[2]} {
+		if err = tx.Bucket(namesBucket).Put(append([]byte(normalizeQuery(name)+"\x00"), key...), nil); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Bucket(cellsBucket).Put(append(cellKey(cellOf(place.Lat, place.Lon)), key...), nil)
+}
+
+// importGeoNamesPostal stores a line of a postal code dump, whose columns are the country, postal code,
+// place name, three levels of administrative names and codes, latitude, longitude and accuracy.
+func importGeoNamesPostal(tx *bbolt.Tx, fields []string) error {
+	postal := geoNamesPostal{Code: fields[1], Name: fields[2], Country: fields[0], State: fields[3]}
+	var err error
+	if postal.Lat, err = strconv.ParseFloat(fields[9], 64); err != nil {
+		return fmt.Errorf("invalid latitude '%s'", fields[9])
+	}
+	if postal.Lon, err = strconv.ParseFloat(fields[10], 64); err != nil {
+		return fmt.Errorf("invalid longitude '%s'", fields[10])
+	}
+
+	value, err := json.Marshal(postal)
+	if err != nil {
+		return err
+	}
+	key := normalizePostal(postal.Code) + "\x00" + postal.Country + "\x00" + postal.Name
+	return tx.Bucket(postalBucket).Put([]byte(key), value)
+}
+
+// placesNamed returns every place named name, by its name or its ASCII name, ignoring case and spacing.
+func (x *GeoNamesIndex) placesNamed(name string) ([]geoNamesPlace, error) {
+	var places []geoNamesPlace
+	err := x.db.View(func(tx *bbolt.Tx) error {
+		prefix := []byte(normalizeQuery(name) + "\x00")
+		seen := map[string]bool{}
+		c := tx.Bucket(namesBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+			id := k[len(prefix):]
+			if seen[string(id)] {
+				continue // named the same in ASCII
+			}
+			seen[string(id)] = true
+
+			place, err := getGeoNamesPlace(tx, id)
+			if err != nil {
+				return err
+			}
+			places = append(places, place)
+		}
+		return nil
+	})
+	return places, err
+}
+
+// postalCodes returns the places of a postal code, within country unless it is empty.
+func (x *GeoNamesIndex) postalCodes(code, country string) ([]geoNamesPostal, error) {
+	var found []geoNamesPostal
+	err := x.db.View(func(tx *bbolt.Tx) error {
+		prefix := normalizePostal(code) + "\x00"
+		if country != "" {
+			prefix += country + "\x00"
+		}
+		c := tx.Bucket(postalBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			var postal geoNamesPostal
+			if err := json.Unmarshal(v, &postal); err != nil {
+				return err
+			}
+			found = append(found, postal)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// nearbyPlace is a place along with its distance from the coordinates it was found near.
+type nearbyPlace struct {
+	geoNamesPlace
+	km float64
+}
+
+// maxCellRing is how many cells away from the coordinates nearest looks, about 2,000 km at the equator.
+const maxCellRing = 20
+
+// nearest returns up to limit places nearest to lat and lon, nearest first. It looks through rings of grid
+// cells around the coordinates, until no place in the next ring can be nearer than those found.
+func (x *GeoNamesIndex) nearest(lat, lon float64, limit int) ([]geoNamesPlace, error) {
+	var candidates []nearbyPlace
+	err := x.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(cellsBucket).Cursor()
+		latCell, lonCell := cellOf(lat, lon)
+		for ring := 0; ring <= maxCellRing; ring++ {
+			for dLat := -ring; dLat <= ring; dLat++ {
+				for dLon := -ring; dLon <= ring; dLon++ {
+					if max(abs(dLat), abs(dLon)) != ring || latCell+dLat < 0 || latCell+dLat >= 180 {
+						continue // inside the previous rings, or beyond a pole
+					}
+					prefix := cellKey(latCell+dLat, (lonCell+dLon+360)%360)
+					for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+						place, err := getGeoNamesPlace(tx, k[len(prefix):])
+						if err != nil {
+							return err
+						}
+						candidates = append(candidates, nearbyPlace{place, distanceKm(lat, lon, place.Lat, place.Lon)})
+					}
+				}
+			}
+
+			slices.SortFunc(candidates, func(a, b nearbyPlace) int { return cmp.Compare(a.km, b.km) })
+			if len(candidates) >= limit && candidates[limit-1].km <= ringKm(lat, ring) {
+				break
+			}
+		}
+		return nil
+	})
+
+	var places []geoNamesPlace
+	for _, candidate := range candidates[:min(limit, len(candidates))] {
+		places = append(places, candidate.geoNamesPlace)
+	}
+	return places, err
+}
+
+// region returns the name of a first level administrative division, such as a state, by its code, along with
+// its name in ASCII, when the regions were imported.
+func (x *GeoNamesIndex) region(country, admin1 string) (string, string, error) {
+	var name, ascii string
+	err := x.db.View(func(tx *bbolt.Tx) error {
+		value := string(tx.Bucket(regionsBucket).Get([]byte(country + "." + admin1)))
+		name, ascii, _ = strings.Cut(value, "\t")
+		return nil
+	})
+	return name, ascii, err
+}
+
+func getGeoNamesPlace(tx *bbolt.Tx, id []byte) (geoNamesPlace, error) {
+	var place geoNamesPlace
+	err := json.Unmarshal(tx.Bucket(placesBucket).Get(id), &place)
+	return place, err
+}
+
+// cellOf returns the 1° grid cell holding a point, as the degrees of latitude and longitude from the south west
+// corner of the map.
+func cellOf(lat, lon float64) (int, int) {
+	latCell := min(max(int(math.Floor(lat))+90, 0), 179)
+	lonCell := ((int(math.Floor(lon))+180)%360 + 360) % 360 // 180° is -180°
+	return latCell, lonCell
+}
+
+func cellKey(latCell, lonCell int) []byte {
+	return []byte{byte(latCell), byte(lonCell >> 8), byte(lonCell)}
+}
+
+// ringKm is the least distance from a point to any cell beyond ring cells around its own, the width of ring
+// cells at the latitude nearest a pole that they reach.
+func ringKm(lat float64, ring int) float64 {
+	nearestPole := math.Min(math.Abs(lat)+float64(ring)+1, 90)
+	return float64(ring) * 111.2 * math.Cos(nearestPole*math.Pi/180)
+}
+
+// distanceKm is the great circle distance between two points, by the haversine formula.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// normalizePostal keys postal codes by their letters and digits, so "K1A 0B6" and "k1a0b6" are the same code.
+func normalizePostal(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
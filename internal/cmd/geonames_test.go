@@ -0,0 +1,77 @@
+package cmd_test
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeoNamesIndex_Import(t *testing.T) {
+	index, err := internalcmd.OpenGeoNames(filepath.Join(t.TempDir(), internalcmd.GeoNamesFileName), false)
+	if err != nil {
+		t.Fatalf("OpenGeoNames() Unexpected error: %v", err)
+	}
+	defer index.Close()
+
+	tests := map[string]struct {
+		file     string
+		expected internalcmd.GeoNamesImport
+	}{
+		"populated places":  {file: "cities.txt", expected: internalcmd.GeoNamesImport{Places: 6}},
+		"postal codes":      {file: "postalCodes.txt", expected: internalcmd.GeoNamesImport{PostalCodes: 4}},
+		"region names":      {file: "admin1CodesASCII.txt", expected: internalcmd.GeoNamesImport{Regions: 4}},
+		"places once again": {file: "cities.txt", expected: internalcmd.GeoNamesImport{Places: 6}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "geonames", tc.file))
+			if err != nil {
+				t.Fatalf("Open() Unexpected error: %v", err)
+			}
+			defer f.Close()
+
+			counts, err := index.Import(f)
+			if err != nil {
+				t.Fatalf("Import() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, counts); diff != "" {
+				t.Errorf("Import() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGeoNamesIndex_ImportErrors(t *testing.T) {
+	index, err := internalcmd.OpenGeoNames(filepath.Join(t.TempDir(), internalcmd.GeoNamesFileName), false)
+	if err != nil {
+		t.Fatalf("OpenGeoNames() Unexpected error: %v", err)
+	}
+	defer index.Close()
+
+	tests := map[string]string{
+		"not a dump":        "id,address\n1,Richmond\n",
+		"uneven columns":    "US.VA\tVirginia\tVirginia\t6254928\nUS.KY\tKentucky\n",
+		"invalid latitude":  "US\t23228\tHenrico\tVirginia\tVA\tHenrico\t087\t\t\tnorth\t-77.4957\t4\n",
+		"invalid geonameid": strings.Repeat("x\t", 6) + "P" + strings.Repeat("\tx", 12) + "\n",
+	}
+
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := index.Import(strings.NewReader(input)); err == nil {
+				t.Errorf("Import() Expected error, but didn't get one")
+			}
+		})
+	}
+}
+
+func TestOpenGeoNames_NotImported(t *testing.T) {
+	_, err := internalcmd.OpenGeoNames(filepath.Join(t.TempDir(), internalcmd.GeoNamesFileName), true)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenGeoNames() Unexpected error: %v, expected %v", err, os.ErrNotExist)
+	}
+}
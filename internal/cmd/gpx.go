@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+const gpxHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="geo" xmlns="http://www.topografix.com/GPX/1/1">
+`
+
+const gpxFooter = `</gpx>
+`
+
+// gpxWaypoint is a GPX 1.1 waypoint.
+// see https://www.topografix.com/GPX/1/1/#type_wptType
+type gpxWaypoint struct {
+	XMLName     xml.Name `xml:"wpt"`
+	Lat         string   `xml:"lat,attr"`
+	Lon         string   `xml:"lon,attr"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"desc"`
+	Type        string   `xml:"type"`
+}
+
+// gpxFormat writes a GPX document, with one waypoint per record named after its query.
+type gpxFormat struct {
+	w       io.Writer
+	started bool
+}
+
+func newGpxFormat(w io.Writer) *gpxFormat {
+	return &gpxFormat{w: w}
+}
+
+func (f *gpxFormat) WriteLookup(lookup Lookup) error {
+	if err := f.start(); err != nil {
+		return err
+	}
+
+	for _, r := range lookup.Records {
+		err := writeXMLElement(f.w, "  ", gpxWaypoint{
+			Lat:         formatCoordinate(r.Lat),
+			Lon:         formatCoordinate(r.Lon),
+			Name:        r.Query,
+			Description: describeRecord(r),
+			Type:        string(r.Type),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *gpxFormat) Close() error {
+	if err := f.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(f.w, gpxFooter)
+	return err
+}
+
+func (f *gpxFormat) start() error {
+	if f.started {
+		return nil
+	}
+	f.started = true
+	_, err := io.WriteString(f.w, gpxHeader)
+	return err
+}
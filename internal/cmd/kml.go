@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const kmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+  <Document>
+    <name>geo</name>
+`
+
+const kmlFooter = `  </Document>
+</kml>
+`
+
+// kmlIcon is the Google Earth pushpin, tinted per query by kmlColors.
+const kmlIcon = "https://maps.google.com/mapfiles/kml/pushpin/wht-pushpin.png"
+
+// kmlColors are the icon tints given to each query in turn, in KML's aabbggrr notation.
+var kmlColors = []string{
+	"ff0000ff", // red
+	"ffff0000", // blue
+	"ff00ff00", // green
+	"ff00ffff", // yellow
+	"ffff00ff", // magenta
+	"ffffff00", // cyan
+	"ff0080ff", // orange
+	"ffff0080", // purple
+}
+
+// kmlPlacemark is a Point Placemark with its own style, as KML requires shared styles
+// to precede every Placemark and we only learn the queries as they are looked up.
+// see https://developers.google.com/kml/documentation/kmlreference#placemark
+type kmlPlacemark struct {
+	XMLName     xml.Name `xml:"Placemark"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"description"`
+	Style       struct {
+		IconStyle struct {
+			Color string `xml:"color"`
+			Icon  struct {
+				Href string `xml:"href"`
+			} `xml:"Icon"`
+		} `xml:"IconStyle"`
+	} `xml:"Style"`
+	Point struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point"`
+}
+
+// kmlFormat writes a KML Document, with one Placemark per record named after its query.
+type kmlFormat struct {
+	w       io.Writer
+	colors  map[string]string
+	started bool
+}
+
+func newKmlFormat(w io.Writer) *kmlFormat {
+	return &kmlFormat{w: w, colors: map[string]string{}}
+}
+
+func (f *kmlFormat) WriteLookup(lookup Lookup) error {
+	if err := f.start(); err != nil {
+		return err
+	}
+
+	color, ok := f.colors[lookup.Query]
+	if !ok {
+		color = kmlColors[len(f.colors)%len(kmlColors)]
+		f.colors[lookup.Query] = color
+	}
+
+	for _, r := range lookup.Records {
+		p := kmlPlacemark{
+			Name:        r.Query,
+			Description: describeRecord(r),
+		}
+		p.Style.IconStyle.Color = color
+		p.Style.IconStyle.Icon.Href = kmlIcon
+		p.Point.Coordinates = fmt.Sprintf("%s,%s", formatCoordinate(r.Lon), formatCoordinate(r.Lat))
+
+		if err := writeXMLElement(f.w, "    ", p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *kmlFormat) Close() error {
+	if err := f.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(f.w, kmlFooter)
+	return err
+}
+
+func (f *kmlFormat) start() error {
+	if f.started {
+		return nil
+	}
+	f.started = true
+	_, err := io.WriteString(f.w, kmlHeader)
+	return err
+}
+
+// describeRecord joins the non-empty place fields of a record, such as "Henrico, Virginia, US".
+func describeRecord(r Record) string {
+	var parts []string
+	for _, part := range []string{r.Name, r.State, r.Country, r.Zip} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
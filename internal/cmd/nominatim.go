@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultNominatimURL is the public OpenStreetMap Nominatim server used when Nominatim.BaseURL is empty.
+const DefaultNominatimURL = "https://nominatim.openstreetmap.org"
+
+// publicNominatimHost is the host of the public server, however its URL is spelled.
+const publicNominatimHost = "nominatim.openstreetmap.org"
+
+// DefaultNominatimUserAgent identifies geo to Nominatim when Nominatim.UserAgent is empty.
+const DefaultNominatimUserAgent = "geo (https://github.com/squeedee/geo)"
+
+// publicNominatimLimiter keeps every lookup against the public server to one a second, as its usage policy asks.
+// It is shared by every Nominatim, as the policy applies to the application rather than to a client.
+var publicNominatimLimiter = NewRateLimiter(60, 1)
+
+// Nominatim looks places up with a Nominatim server, the public OpenStreetMap one or a self-hosted one.
+// Requests to the public server are never sent more than one a second, whatever the Limiter allows.
+// see https://nominatim.org/release-docs/latest/api/Overview/
+// see https://operations.osmfoundation.org/policies/nominatim/
+type Nominatim struct {
+	BaseURL   string       // Server root, DefaultNominatimURL when empty
+	UserAgent string       // Identifies the application, as the usage policy requires, DefaultNominatimUserAgent when empty
+	Email     string       // Contact for the server operators, optional
+	Country   string       // ISO 3166 alpha-2 country of names and postal codes that do not name one, worldwide when empty
+	Lang      string       // Preferred language of place names, such as "es", the server's default when empty
+	Limit     int          // Most places a search returns, left to the server when 0
+	Client    *http.Client // Client used for requests, http.DefaultClient when nil
+	Limiter   *RateLimiter // Paces every request, unlimited when nil other than for the public server
+	Retry     *RetryPolicy // Retries transient failures, never retries when nil
+}
+
+// StructuredQuery is a place broken into its address parts, searched with Nominatim.SearchStructured.
+// Any part may be left empty, but at least one must be set.
+type StructuredQuery struct {
+	Street     string // House number and street name
+	City       string
+	County     string
+	State      string
+	Country    string // Country name or ISO 3166 code
+	PostalCode string
+}
+
+// nominatimResult is a place in a jsonv2 response of the search and reverse endpoints.
+type nominatimResult struct {
+	OSMType     string           `json:"osm_type"`
+	OSMID       int64            `json:"osm_id"`
+	Lat         string           `json:"lat"`
+	Lon         string           `json:"lon"`
+	Name        string           `json:"name"`
+	DisplayName string           `json:"display_name"`
+	Address     nominatimAddress `json:"address"`
+	BoundingBox []string         `json:"boundingbox"` // south, north, west, east
+	Error       string           `json:"error"`       // set instead of a place when reverse finds nothing
+}
+
+type nominatimAddress struct {
+	City        string `json:"city"`
+	Town        string `json:"town"`
+	Village     string `json:"village"`
+	Hamlet      string `json:"hamlet"`
+	County      string `json:"county"`
+	State       string `json:"state"`
+	Postcode    string `json:"postcode"`
+	CountryCode string `json:"country_code"`
+}
+
+// Search implements Geocoder with a free-form search. A name ending in a country, such as "Paris, France",
+// is searched within that country, and any other within Country when it is set, see searchCountry.
+// see https://nominatim.org/release-docs/latest/api/Search/
+func (n *Nominatim) Search(ctx context.Context, name string) ([]Place, error) {
+	_, country := searchCountry(name, n.Country)
+
+	q := url.Values{}
+	q.Set("q", name)
+	n.addCountry(q, country)
+	return n.search(ctx, q, "")
+}
+
+// SearchPostalCode implements Geocoder with a structured search for the postal code, within its country when known.
+func (n *Nominatim) SearchPostalCode(ctx context.Context, postal PostalCode) ([]Place, error) {
+	q := url.Values{}
+	q.Set("postalcode", postal.Code)
+	n.addCountry(q, postal.Country)
+	return n.search(ctx, q, postal.Code)
+}
+
+// SearchStructured searches for a place by its address parts, which is more precise than a free-form
+// search when the parts are already known, such as the columns of an address book.
+// see https://nominatim.org/release-docs/latest/api/Search/#structured-query
+func (n *Nominatim) SearchStructured(ctx context.Context, query StructuredQuery) ([]Place, error) {
+	q := url.Values{}
+	for key, value := range map[string]string{
+		"street":     query.Street,
+		"city":       query.City,
+		"county":     query.County,
+		"state":      query.State,
+		"country":    query.Country,
+		"postalcode": query.PostalCode,
+	} {
+		if value = strings.TrimSpace(value); value != "" {
+			q.Set(key, value)
+		}
+	}
+	if len(q) == 0 {
+		return nil, fmt.Errorf("a structured query needs at least one address part")
+	}
+	if query.Country == "" {
+		n.addCountry(q, n.Country)
+	}
+	return n.search(ctx, q, query.PostalCode)
+}
+
+// Reverse implements Geocoder. Nominatim only returns the single nearest place, so limit is ignored.
+// see https://nominatim.org/release-docs/latest/api/Reverse/
+func (n *Nominatim) Reverse(ctx context.Context, lat, lon float64, limit int) ([]Place, error) {
+	q := url.Values{}
+	q.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	q.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+
+	body, err := n.get(ctx, "reverse", q)
+	if err != nil {
+		return nil, err
+	}
+
+	var result nominatimResult
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, nil // nothing there, such as the middle of an ocean
+	}
+	return []Place{result.place("")}, nil
+}
+
+// search calls the search endpoint with q, recording zip as the postal code of every place found.
+func (n *Nominatim) search(ctx context.Context, q url.Values, zip string) ([]Place, error) {
+	if n.Limit > 0 {
+		q.Set("limit", strconv.Itoa(n.Limit))
+	}
+
+	body, err := n.get(ctx, "search", q)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []nominatimResult
+	if err = json.Unmarshal(body, &results); err != nil {
+		return nil, err
+	}
+
+	var places []Place
+	for _, r := range results {
+		places = append(places, r.place(zip))
+	}
+	return places, nil
+}
+
+func (n *Nominatim) addCountry(q url.Values, country string) {
+	if country != "" {
+		q.Set("countrycodes", strings.ToLower(country))
+	}
+}
+
+// get calls an endpoint with the parameters every request shares, pacing requests to the public server.
+func (n *Nominatim) get(ctx context.Context, endpoint string, q url.Values) ([]byte, error) {
+	uri, err := baseURL(n.BaseURL, DefaultNominatimURL)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Set("format", "jsonv2")
+	q.Set("addressdetails", "1")
+	if n.Lang != "" {
+		q.Set("accept-language", n.Lang)
+	}
+	if n.Email != "" {
+		q.Set("email", n.Email)
+	}
+	uri = uri.JoinPath(endpoint)
+	uri.RawQuery = q.Encode()
+
+	var limiter pacer = n.Limiter
+	if strings.EqualFold(uri.Hostname(), publicNominatimHost) {
+		limiter = rateLimiters{n.Limiter, publicNominatimLimiter} // the usage policy allows one request a second at most, without bursts
+	}
+
+	userAgent := n.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultNominatimUserAgent
+	}
+	return httpGet(ctx, n.Client, limiter, n.Retry, uri.String(), http.Header{"User-Agent": {userAgent}})
+}
+
+// place maps a result to the fields OpenWeather reports, along with its extent and OpenStreetMap element.
+func (r nominatimResult) place(zip string) Place {
+	lat, _ := strconv.ParseFloat(r.Lat, 64)
+	lon, _ := strconv.ParseFloat(r.Lon, 64)
+
+	// Postal codes and addresses have no name of their own, so they are named after the place they are in.
+	displayName, _, _ := strings.Cut(r.DisplayName, ",")
+	name := firstNonEmpty(r.Name, r.Address.City, r.Address.Town, r.Address.Village, r.Address.Hamlet, r.Address.County, displayName)
+
+	if zip != "" && r.Address.Postcode != "" {
+		zip = r.Address.Postcode
+	}
+
+	p := Place{
+		Name:    name,
+		State:   r.Address.State,
+		Country: strings.ToUpper(r.Address.CountryCode),
+		Zip:     zip,
+		Lat:     lat,
+		Lon:     lon,
+		OSMType: r.OSMType,
+		OSMID:   r.OSMID,
+	}
+
+	if len(r.BoundingBox) == 4 {
+		var bounds [4]float64
+		for i, b := range r.BoundingBox {
+			bounds[i], _ = strconv.ParseFloat(b, 64)
+		}
+		p.BBox = []float64{bounds[2], bounds[0], bounds[3], bounds[1]}
+	}
+	return p
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
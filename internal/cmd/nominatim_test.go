@@ -0,0 +1,282 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const nominatimRichmond = `{"place_id":1,"osm_type":"relation","osm_id":206629,"lat":"37.5385087","lon":"-77.43428",
+"name":"Richmond","display_name":"Richmond, Virginia, United States",
+"address":{"city":"Richmond","state":"Virginia","country_code":"us"},
+"boundingbox":["37.4470","37.6057","-77.6011","-77.3853"]}`
+
+const nominatimPostcode = `{"place_id":2,"osm_type":"node","osm_id":42,"lat":"37.4638","lon":"-77.398",
+"name":"","display_name":"23228, Henrico County, Virginia, United States",
+"address":{"county":"Henrico County","state":"Virginia","postcode":"23228","country_code":"us"},
+"boundingbox":["37.4138","37.5138","-77.448","-77.348"]}`
+
+var richmondPlace = internalcmd.Place{
+	Name: "Richmond", State: "Virginia", Country: "US", Lat: 37.5385087, Lon: -77.43428,
+	BBox: []float64{-77.6011, 37.4470, -77.3853, 37.6057}, OSMType: "relation", OSMID: 206629,
+}
+
+// nominatimStub serves canned Nominatim responses, recording every request it saw.
+func nominatimStub(t *testing.T, requests *[]*http.Request) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		q := r.URL.Query()
+		switch {
+		case q.Get("q") == "fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/nominatim/reverse" && q.Get("lat") == "0":
+			_, _ = w.Write([]byte(`{"error":"Unable to geocode"}`))
+		case r.URL.Path == "/nominatim/reverse":
+			_, _ = w.Write([]byte(nominatimRichmond))
+		case q.Get("postalcode") != "":
+			_, _ = w.Write([]byte("[" + nominatimPostcode + "]"))
+		case q.Get("q") == "nowhere":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			_, _ = w.Write([]byte("[" + nominatimRichmond + "]"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNominatim(t *testing.T) {
+	var requests []*http.Request
+	server := nominatimStub(t, &requests)
+
+	n := &internalcmd.Nominatim{
+		BaseURL:   server.URL + "/nominatim",
+		UserAgent: "geo-test",
+		Country:   "US",
+	}
+	worldwide := *n
+	worldwide.Country = ""
+	french := *n
+	french.Country = "FR"
+	localized := *n
+	localized.Lang = "es"
+	localized.Limit = 3
+
+	shared := url.Values{"format": {"jsonv2"}, "addressdetails": {"1"}}
+	with := func(values url.Values) url.Values {
+		for key, v := range shared {
+			values[key] = v
+		}
+		return values
+	}
+
+	tests := map[string]struct {
+		lookup        func() ([]internalcmd.Place, error)
+		expectedPath  string
+		expectedQuery url.Values
+		expected      []internalcmd.Place
+	}{
+		"Search": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Search(context.Background(), "Richmond, VA") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Richmond, VA"}, "countrycodes": {"us"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search naming a country": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Search(context.Background(), "Richmond, UK") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Richmond, UK"}, "countrycodes": {"gb"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search ending in a US state that is also a country code": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Search(context.Background(), "Richmond, VA, USA") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Richmond, VA, USA"}, "countrycodes": {"us"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search ending in a US state without a default country": {
+			lookup:        func() ([]internalcmd.Place, error) { return worldwide.Search(context.Background(), "Chicago, IL") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Chicago, IL"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search ending in a US state with another default country": {
+			lookup:        func() ([]internalcmd.Place, error) { return french.Search(context.Background(), "Denver, CO") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Denver, CO"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search ending in a US state in three parts": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return n.Search(context.Background(), "Springfield, Sangamon County, IL")
+			},
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Springfield, Sangamon County, IL"}, "countrycodes": {"us"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search naming a country by alpha-3 code": {
+			lookup:        func() ([]internalcmd.Place, error) { return worldwide.Search(context.Background(), "Bogota, COL") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Bogota, COL"}, "countrycodes": {"co"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search with a language and limit": {
+			lookup:        func() ([]internalcmd.Place, error) { return localized.Search(context.Background(), "Richmond") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"Richmond"}, "countrycodes": {"us"}, "accept-language": {"es"}, "limit": {"3"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Search without matches": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Search(context.Background(), "nowhere") },
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"q": {"nowhere"}, "countrycodes": {"us"}}),
+		},
+		"SearchPostalCode": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return n.SearchPostalCode(context.Background(), internalcmd.PostalCode{Code: "23228", Country: "US"})
+			},
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"postalcode": {"23228"}, "countrycodes": {"us"}}),
+			expected: []internalcmd.Place{{
+				Name: "Henrico County", State: "Virginia", Country: "US", Zip: "23228", Lat: 37.4638, Lon: -77.398,
+				BBox: []float64{-77.448, 37.4138, -77.348, 37.5138}, OSMType: "node", OSMID: 42,
+			}},
+		},
+		"SearchStructured": {
+			lookup: func() ([]internalcmd.Place, error) {
+				return n.SearchStructured(context.Background(), internalcmd.StructuredQuery{City: "Richmond", State: "Virginia"})
+			},
+			expectedPath:  "/nominatim/search",
+			expectedQuery: with(url.Values{"city": {"Richmond"}, "state": {"Virginia"}, "countrycodes": {"us"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Reverse": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Reverse(context.Background(), 37.5385087, -77.43428, 5) },
+			expectedPath:  "/nominatim/reverse",
+			expectedQuery: with(url.Values{"lat": {"37.5385087"}, "lon": {"-77.43428"}}),
+			expected:      []internalcmd.Place{richmondPlace},
+		},
+		"Reverse without a place": {
+			lookup:        func() ([]internalcmd.Place, error) { return n.Reverse(context.Background(), 0, 0, 5) },
+			expectedPath:  "/nominatim/reverse",
+			expectedQuery: with(url.Values{"lat": {"0"}, "lon": {"0"}}),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests = nil
+			places, err := tc.lookup()
+			if err != nil {
+				t.Fatalf("%s() Unexpected error: %v", name, err)
+			}
+			if len(requests) != 1 {
+				t.Fatalf("%s() Expected 1 request, got %d", name, len(requests))
+			}
+			if requests[0].URL.Path != tc.expectedPath {
+				t.Errorf("%s() Unexpected path: %s, expected %s", name, requests[0].URL.Path, tc.expectedPath)
+			}
+			if diff := cmp.Diff(tc.expectedQuery, requests[0].URL.Query()); diff != "" {
+				t.Errorf("%s() query mismatch (-want +got):\n%s", name, diff)
+			}
+			if ua := requests[0].Header.Get("User-Agent"); ua != "geo-test" {
+				t.Errorf("%s() sent User-Agent '%s', expected 'geo-test'", name, ua)
+			}
+			if diff := cmp.Diff(tc.expected, places); diff != "" {
+				t.Errorf("%s() mismatch (-want +got):\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestNominatim_Errors(t *testing.T) {
+	var requests []*http.Request
+	server := nominatimStub(t, &requests)
+	n := &internalcmd.Nominatim{BaseURL: server.URL + "/nominatim"}
+
+	if _, err := n.Search(context.Background(), "fail"); !errors.Is(err, internalcmd.ErrUpstream) {
+		t.Errorf("Search() Unexpected error: %v, expected %v", err, internalcmd.ErrUpstream)
+	}
+	if _, err := n.SearchStructured(context.Background(), internalcmd.StructuredQuery{}); err == nil {
+		t.Errorf("SearchStructured() Expected error, but didn't get one")
+	}
+	if ua := requests[0].Header.Get("User-Agent"); ua != internalcmd.DefaultNominatimUserAgent {
+		t.Errorf("Search() sent User-Agent '%s', expected the default", ua)
+	}
+}
+
+func TestNominatim_PublicServerPacing(t *testing.T) {
+	// The user's limit allows a burst of 10, which the public server's usage policy does not.
+	limiter := internalcmd.NewRateLimiter(30, 10)
+
+	tests := map[string]struct {
+		baseURL    string
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		"public server, one request a second": {
+			minElapsed: 950 * time.Millisecond,
+			maxElapsed: time.Minute,
+		},
+		"public server over http, one request a second": {
+			baseURL:    "http://nominatim.openstreetmap.org",
+			minElapsed: 950 * time.Millisecond,
+			maxElapsed: time.Minute,
+		},
+		"public server with an upper-case host and a path, one request a second": {
+			baseURL:    "https://Nominatim.OpenStreetMap.org/search/",
+			minElapsed: 950 * time.Millisecond,
+			maxElapsed: time.Minute,
+		},
+		"self-hosted server, paced by the limiter alone": {
+			baseURL:    "https://nominatim.example.com",
+			maxElapsed: 100 * time.Millisecond,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := &stubTransport{StatusCode: http.StatusOK, Body: `[]`}
+			n := &internalcmd.Nominatim{BaseURL: tc.baseURL, Client: &http.Client{Transport: transport}, Limiter: limiter}
+
+			start := time.Now()
+			for i := 0; i < 2; i++ {
+				if _, err := n.Search(context.Background(), "Richmond"); err != nil {
+					t.Fatalf("Search() Unexpected error: %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tc.minElapsed || elapsed > tc.maxElapsed {
+				t.Errorf("Search() took %s for 2 requests, expected between %s and %s", elapsed, tc.minElapsed, tc.maxElapsed)
+			}
+			if len(transport.Requests) != 2 {
+				t.Errorf("Search() made %d requests, expected 2", len(transport.Requests))
+			}
+		})
+	}
+}
+
+func TestNominatim_Resolve(t *testing.T) {
+	var requests []*http.Request
+	server := nominatimStub(t, &requests)
+	n := &internalcmd.Nominatim{BaseURL: server.URL + "/nominatim", Country: "US"}
+
+	lookup, err := internalcmd.NewResolver(n, "US")(context.Background(), "Richmond, VA")
+	if err != nil {
+		t.Fatalf("Resolver() Unexpected error: %v", err)
+	}
+
+	expected := internalcmd.Lookup{Query: "Richmond, VA", Type: internalcmd.LookupName, Records: []internalcmd.Record{{
+		Query: "Richmond, VA", Type: internalcmd.LookupName, Name: "Richmond", State: "Virginia", StateCode: "VA",
+		Country: "US", Lat: 37.5385087, Lon: -77.43428,
+		BBox: []float64{-77.6011, 37.4470, -77.3853, 37.6057}, OSMType: "relation", OSMID: 206629,
+	}}}
+	if diff := cmp.Diff(expected, lookup); diff != "" {
+		t.Errorf("Resolver() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+)
+
+// DefaultOfflineLimit is the most places Offline returns for a name or coordinates when Limit is 0,
+// as many as OpenWeather returns at most.
+const DefaultOfflineLimit = 5
+
+// Offline is a Geocoder answering from a GeoNames index, for machines that cannot reach any API.
+// Names match the name of a populated place exactly, ignoring case, and the most populous places come first.
+type Offline struct {
+	Index   *GeoNamesIndex
+	Country string // ISO 3166 alpha-2 country of names that do not name one, worldwide when empty
+	Limit   int    // Most places a search or reverse lookup returns, DefaultOfflineLimit when 0
+}
+
+// Search implements Geocoder. Names are read as QualifyName reads them, as "<city>, <region>, <country>",
+// the region being a US state or, when the regions were imported, the name or code of any country's first
+// level division, such as "Ontario" or "08". A US name without commas that matches nothing, such as "richmond va",
+// is searched once more with its state split off, as DirectGeocoding does.
+func (o *Offline) Search(ctx context.Context, name string) ([]Place, error) {
+	parts, country := searchCountry(name, o.Country)
+	if country != "US" {
+		return o.search(parts, country)
+	}
+
+	found, err := o.search(withUSStateCode(parts), country)
+	if split, ok := splitUSState(parts); err == nil && len(found) == 0 && ok {
+		return o.search(split, country)
+	}
+	return found, err
+}
+
+// search returns the places named by the first of parts, in country when set and in the region named by the last.
+func (o *Offline) search(parts []string, country string) ([]Place, error) {
+	places, err := o.Index.placesNamed(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(places, func(a, b geoNamesPlace) int {
+		return cmp.Compare(b.Population, a.Population)
+	})
+
+	var found []Place
+	for _, p := range places {
+		if country != "" && p.Country != country {
+			continue
+		}
+		if len(parts) > 1 {
+			in, err := o.inRegion(parts[len(parts)-1], p)
+			if err != nil {
+				return nil, err
+			}
+			if !in {
+				continue
+			}
+		}
+		place, err := o.place(p)
+		if err != nil {
+			return nil, err
+		}
+		if found = append(found, place); len(found) == o.limit() {
+			break
+		}
+	}
+	return found, nil
+}
+
+// SearchPostalCode implements Geocoder. The GeoNames dumps only have the first part of some postal codes, such as
+// "K1A" of "K1A 0B6" in Canada, so a code that is not found is looked up again by the part before its space.
+func (o *Offline) SearchPostalCode(ctx context.Context, postal PostalCode) ([]Place, error) {
+	found, err := o.Index.postalCodes(postal.Code, postal.Country)
+	if outward, _, ok := strings.Cut(postal.Code, " "); err == nil && len(found) == 0 && ok {
+		found, err = o.Index.postalCodes(outward, postal.Country)
+	}
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+
+	p := found[0]
+	return []Place{{Name: p.Name, State: p.State, Country: p.Country, Zip: p.Code, Lat: p.Lat, Lon: p.Lon}}, nil
+}
+
+// Reverse implements Geocoder with the populated places nearest to the coordinates.
+func (o *Offline) Reverse(ctx context.Context, lat, lon float64, limit int) ([]Place, error) {
+	if limit <= 0 {
+		limit = o.limit()
+	}
+	nearest, err := o.Index.nearest(lat, lon, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var places []Place
+	for _, p := range nearest {
+		place, err := o.place(p)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, place)
+	}
+	return places, nil
+}
+
+func (o *Offline) limit() int {
+	if o.Limit <= 0 {
+		return DefaultOfflineLimit
+	}
+	return o.Limit
+}
+
+// place names the region of p, from the imported regions or, in the US, its state code.
+func (o *Offline) place(p geoNamesPlace) (Place, error) {
+	state, _, err := o.Index.region(p.Country, p.Admin1)
+	if err != nil {
+		return Place{}, err
+	}
+	if state == "" && p.Country == "US" {
+		state = usStates[p.Admin1]
+	}
+	return Place{Name: p.Name, State: state, Country: p.Country, Lat: p.Lat, Lon: p.Lon}, nil
+}
+
+// inRegion reports whether region, as given in a query, names the first level division of p, by its code,
+// its name or its ASCII name. A region that is both a US state and a country, such as "CA", may name either,
+// see searchCountry.
+func (o *Offline) inRegion(region string, p geoNamesPlace) (bool, error) {
+	if strings.EqualFold(region, p.Admin1) {
+		return true, nil
+	}
+	if c, ok := CountryByName(region); ok && c.Alpha2 == p.Country && isUSState(region) {
+		return true, nil
+	}
+	name, ascii, err := o.Index.region(p.Country, p.Admin1)
+	if p.Country == "US" && name == "" {
+		name = usStates[p.Admin1]
+	}
+	return strings.EqualFold(region, name) || strings.EqualFold(region, ascii), err
+}
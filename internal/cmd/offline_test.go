@@ -0,0 +1,197 @@
+package cmd_test
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	richmondVA = internalcmd.Place{Name: "Richmond", State: "Virginia", Country: "US", Lat: 37.55376, Lon: -77.46026}
+	richmondCA = internalcmd.Place{Name: "Richmond", State: "California", Country: "US", Lat: 37.93576, Lon: -122.34775}
+	richmondKY = internalcmd.Place{Name: "Richmond", State: "Kentucky", Country: "US", Lat: 37.74786, Lon: -84.29465}
+	richmondGB = internalcmd.Place{Name: "Richmond", State: "England", Country: "GB", Lat: 51.46171, Lon: -0.30018}
+)
+
+// newTestIndex imports the GeoNames fixtures into a new index.
+func newTestIndex(t *testing.T) *internalcmd.GeoNamesIndex {
+	index, err := internalcmd.OpenGeoNames(filepath.Join(t.TempDir(), internalcmd.GeoNamesFileName), false)
+	if err != nil {
+		t.Fatalf("OpenGeoNames() Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = index.Close() })
+
+	for _, name := range []string{"cities.txt", "postalCodes.txt", "admin1CodesASCII.txt"} {
+		f, err := os.Open(filepath.Join("testdata", "geonames", name))
+		if err != nil {
+			t.Fatalf("Open() Unexpected error: %v", err)
+		}
+		_, err = index.Import(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatalf("Import(%s) Unexpected error: %v", name, err)
+		}
+	}
+	return index
+}
+
+func TestOffline_Search(t *testing.T) {
+	index := newTestIndex(t)
+
+	tests := map[string]struct {
+		name     string
+		country  string
+		limit    int
+		expected []internalcmd.Place
+	}{
+		"most populous first": {
+			name:     "Richmond",
+			country:  "US",
+			expected: []internalcmd.Place{richmondVA, richmondCA, richmondKY},
+		},
+		"limit": {
+			name:     "richmond",
+			country:  "US",
+			limit:    1,
+			expected: []internalcmd.Place{richmondVA},
+		},
+		"US state by code": {
+			name:     "Richmond, KY",
+			country:  "US",
+			expected: []internalcmd.Place{richmondKY},
+		},
+		"US state without a comma": {
+			name:     "richmond kentucky",
+			country:  "US",
+			expected: []internalcmd.Place{richmondKY},
+		},
+		"country by name": {
+			name:     "Richmond, UK",
+			country:  "US",
+			expected: []internalcmd.Place{richmondGB},
+		},
+		"region by name": {
+			name:     "Paris, Ile-de-France, FR",
+			expected: []internalcmd.Place{{Name: "Paris", State: "Île-de-France", Country: "FR", Lat: 48.85341, Lon: 2.3488}},
+		},
+		"ASCII name": {
+			name:     "Sao Paulo, Brazil",
+			expected: []internalcmd.Place{{Name: "São Paulo", State: "São Paulo", Country: "BR", Lat: -23.5475, Lon: -46.63611}},
+		},
+		"worldwide": {
+			name:     "Richmond",
+			expected: []internalcmd.Place{richmondVA, richmondCA, richmondKY, richmondGB},
+		},
+		"US state that is also a country code, without a default country": {
+			name:     "Richmond, VA",
+			expected: []internalcmd.Place{richmondVA},
+		},
+		"US state that is also a country code, with another default country": {
+			name:     "Richmond, KY",
+			country:  "FR",
+			expected: []internalcmd.Place{richmondKY},
+		},
+		"country code that is also a US state": {
+			name:     "Richmond, CA",
+			expected: []internalcmd.Place{richmondCA},
+		},
+		"outside the default country": {
+			name:    "Paris",
+			country: "US",
+		},
+		"not a populated place": {
+			name: "Mount Rainier",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := &internalcmd.Offline{Index: index, Country: tc.country, Limit: tc.limit}
+			places, err := o.Search(context.Background(), tc.name)
+			if err != nil {
+				t.Fatalf("Search() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, places); diff != "" {
+				t.Errorf("Search() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOffline_SearchPostalCode(t *testing.T) {
+	o := &internalcmd.Offline{Index: newTestIndex(t)}
+
+	tests := map[string]struct {
+		postal   internalcmd.PostalCode
+		expected []internalcmd.Place
+	}{
+		"ZIP code": {
+			postal:   internalcmd.PostalCode{Code: "23228", Country: "US"},
+			expected: []internalcmd.Place{{Name: "Henrico", State: "Virginia", Country: "US", Zip: "23228", Lat: 37.6263, Lon: -77.4957}},
+		},
+		"without a country": {
+			postal:   internalcmd.PostalCode{Code: "75001"},
+			expected: []internalcmd.Place{{Name: "Paris 01 Louvre", State: "Île-de-France", Country: "FR", Zip: "75001", Lat: 48.8592, Lon: 2.3417}},
+		},
+		"outward code": {
+			postal:   internalcmd.PostalCode{Code: "K1A 0B6", Country: "CA"},
+			expected: []internalcmd.Place{{Name: "Ottawa (Parliament Hill)", State: "Ontario", Country: "CA", Zip: "K1A", Lat: 45.4215, Lon: -75.7093}},
+		},
+		"another country": {
+			postal: internalcmd.PostalCode{Code: "75001", Country: "US"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			places, err := o.SearchPostalCode(context.Background(), tc.postal)
+			if err != nil {
+				t.Fatalf("SearchPostalCode() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, places); diff != "" {
+				t.Errorf("SearchPostalCode() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOffline_Reverse(t *testing.T) {
+	o := &internalcmd.Offline{Index: newTestIndex(t)}
+
+	tests := map[string]struct {
+		lat, lon float64
+		limit    int
+		expected []internalcmd.Place
+	}{
+		"nearest first": {
+			lat: 37.5385, lon: -77.4343, limit: 2,
+			expected: []internalcmd.Place{richmondVA, richmondKY},
+		},
+		"in a neighbouring cell": {
+			lat: 51.5, lon: 0.1, limit: 1,
+			expected: []internalcmd.Place{richmondGB},
+		},
+		"default limit": {
+			lat: 37.5385, lon: -77.4343,
+			expected: []internalcmd.Place{richmondVA, richmondKY},
+		},
+		"nowhere near a place": {
+			lat: 30, lon: -40, limit: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			places, err := o.Reverse(context.Background(), tc.lat, tc.lon, tc.limit)
+			if err != nil {
+				t.Fatalf("Reverse() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, places); diff != "" {
+				t.Errorf("Reverse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LookupType names the API used to resolve a query.
+type LookupType string
+
+const (
+	LookupName    LookupType = "name"
+	LookupZip     LookupType = "zip"
+	LookupReverse LookupType = "reverse"
+)
+
+// Record is a single geocoded match, flattened from a NameResult or ZipResult
+// so every output format shares one schema.
+type Record struct {
+	Query     string     `json:"query"`
+	Type      LookupType `json:"type"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	StateCode string     `json:"state_code"` // USPS code of a US state, empty elsewhere
+	Country   string     `json:"country"`
+	Zip       string     `json:"zip"`
+	Lat       float64    `json:"lat"`
+	Lon       float64    `json:"lon"`
+	BBox      []float64  `json:"bbox,omitempty"` // west, south, east, north
+	OSMType   string     `json:"osm_type,omitempty"`
+	OSMID     int64      `json:"osm_id,omitempty"`
+	TractFIPS string     `json:"tract_fips,omitempty"`
+	BlockFIPS string     `json:"block_fips,omitempty"`
+	Relaxed   string     `json:"relaxed,omitempty"` // the simpler form of the query that matched, see Relax
+	Precision Precision  `json:"precision,omitempty"`
+}
+
+// Lookup is a query along with every record it matched.
+type Lookup struct {
+	Query     string     `json:"query"`
+	Type      LookupType `json:"type"`
+	Records   []Record   `json:"records"`
+	Relaxed   string     `json:"relaxed,omitempty"` // the simpler form of the query that matched, see Relax
+	Precision Precision  `json:"precision,omitempty"`
+}
+
+// NewLookup flattens the places a Geocoder found for a query.
+func NewLookup(query string, lookupType LookupType, places []Place) Lookup {
+	lookup := Lookup{Query: query, Type: lookupType}
+	for _, p := range places {
+		lookup.Records = append(lookup.Records, Record{
+			Query:     query,
+			Type:      lookupType,
+			Name:      p.Name,
+			State:     p.State,
+			StateCode: usStateCode(p.Country, p.State),
+			Country:   p.Country,
+			Zip:       p.Zip,
+			Lat:       p.Lat,
+			Lon:       p.Lon,
+			BBox:      p.BBox,
+			OSMType:   p.OSMType,
+			OSMID:     p.OSMID,
+			TractFIPS: p.TractFIPS,
+			BlockFIPS: p.BlockFIPS,
+		})
+	}
+	return lookup
+}
+
+// NewNameLookup flattens the results of an OpenWeather name or reverse lookup.
+func NewNameLookup(query string, lookupType LookupType, results []NameResult) Lookup {
+	return NewLookup(query, lookupType, namePlaces(results, ""))
+}
+
+// NewZipLookup flattens the result of an OpenWeather zip lookup, a nil result has no records.
+func NewZipLookup(query string, result *ZipResult) Lookup {
+	if result == nil {
+		return NewLookup(query, LookupZip, nil)
+	}
+	return NewLookup(query, LookupZip, []Place{result.place()})
+}
+
+// First returns a copy of the lookup keeping only its first record, the API's best match.
+func (l Lookup) First() Lookup {
+	if len(l.Records) > 1 {
+		l.Records = l.Records[:1:1]
+	}
+	return l
+}
+
+// Format renders lookups as they complete.
+type Format interface {
+	// WriteLookup renders every record of a single lookup.
+	WriteLookup(lookup Lookup) error
+	// Close completes the document, such as closing a JSON array. It does not close the underlying writer.
+	Close() error
+}
+
+// Formats lists the names accepted by NewFormat.
+var Formats = []string{"text", "json", "ndjson", "csv", "yaml", "geojson", "kml", "gpx"}
+
+// NewFormat returns the named Format writing to w. The csv format follows its usual columns with the extra ones,
+// such as OSMColumns, the other formats always carry every field a provider reports.
+func NewFormat(name string, w io.Writer, extra []string) (Format, error) {
+	switch name {
+	case "text":
+		return &textFormat{w: w}, nil
+	case "json":
+		return &jsonFormat{w: w}, nil
+	case "ndjson":
+		return &ndjsonFormat{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvFormat{w: csv.NewWriter(w), extra: extra}, nil
+	case "yaml":
+		return &yamlFormat{w: w}, nil
+	case "geojson":
+		return &geojsonFormat{w: w}, nil
+	case "kml":
+		return newKmlFormat(w), nil
+	case "gpx":
+		return newGpxFormat(w), nil
+	}
+	return nil, fmt.Errorf("unknown output format '%s', expected one of: %s", name, strings.Join(Formats, ", "))
+}
+
+// textFormat is the human-readable layout geo has always printed.
+type textFormat struct {
+	w io.Writer
+}
+
+func (f *textFormat) WriteLookup(lookup Lookup) error {
+	if _, err := fmt.Fprintf(f.w, "'%s' results:\n", lookup.Query); err != nil {
+		return err
+	}
+
+	if len(lookup.Records) == 0 {
+		_, err := fmt.Fprintf(f.w, "  No matches found.\n\n")
+		return err
+	}
+	if lookup.Relaxed != "" {
+		if _, err := fmt.Fprintf(f.w, "  No exact match, relaxed to '%s' (%s precision).\n", lookup.Relaxed, lookup.Precision); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range lookup.Records {
+		var err error
+		if r.Type == LookupZip {
+			_, err = fmt.Fprintf(f.w, "  Name: %s, %s, %s\n", r.Name, r.Country, r.Zip)
+		} else {
+			_, err = fmt.Fprintf(f.w, "  Name: %s, %s, %s\n", r.Name, r.State, r.Country)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(f.w, "  Lat,Lon: %f, %f\n\n", r.Lat, r.Lon); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *textFormat) Close() error {
+	return nil
+}
+
+// jsonFormat writes a single indented array of records.
+type jsonFormat struct {
+	w       io.Writer
+	written int
+}
+
+func (f *jsonFormat) WriteLookup(lookup Lookup) error {
+	for _, r := range lookup.Records {
+		b, err := json.MarshalIndent(r, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		sep := ",\n  "
+		if f.written == 0 {
+			sep = "[\n  "
+		}
+		if _, err = fmt.Fprintf(f.w, "%s%s", sep, b); err != nil {
+			return err
+		}
+		f.written++
+	}
+	return nil
+}
+
+func (f *jsonFormat) Close() error {
+	if f.written == 0 {
+		_, err := fmt.Fprintln(f.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(f.w, "\n]")
+	return err
+}
+
+// ndjsonFormat writes one compact record per line.
+type ndjsonFormat struct {
+	enc *json.Encoder
+}
+
+func (f *ndjsonFormat) WriteLookup(lookup Lookup) error {
+	for _, r := range lookup.Records {
+		if err := f.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *ndjsonFormat) Close() error {
+	return nil
+}
+
+var csvHeader = []string{"query", "type", "name", "state", "state_code", "country", "zip", "lat", "lon"}
+
+// OSMColumns and CensusColumns are the extra CSV columns of the fields only Nominatim and the Census geocoder report.
+var (
+	OSMColumns    = []string{"osm_type", "osm_id"}
+	CensusColumns = []string{"tract_fips", "block_fips"}
+)
+
+// extraFields reads the field of each extra CSV column from a record, empty when its provider does not report it.
+var extraFields = map[string]func(Record) string{
+	"osm_type": func(r Record) string { return r.OSMType },
+	"osm_id": func(r Record) string {
+		if r.OSMID == 0 {
+			return ""
+		}
+		return strconv.FormatInt(r.OSMID, 10)
+	},
+	"tract_fips": func(r Record) string { return r.TractFIPS },
+	"block_fips": func(r Record) string { return r.BlockFIPS },
+}
+
+// extraValues returns the fields of r for the extra columns, empty for a column it does not know.
+func extraValues(r Record, extra []string) []string {
+	values := make([]string, len(extra))
+	for i, column := range extra {
+		if field, ok := extraFields[column]; ok {
+			values[i] = field(r)
+		}
+	}
+	return values
+}
+
+// csvFormat writes a header row followed by one row per record.
+type csvFormat struct {
+	w             *csv.Writer
+	extra         []string
+	headerWritten bool
+}
+
+func (f *csvFormat) writeHeader() error {
+	f.headerWritten = true
+	return f.w.Write(append(append([]string{}, csvHeader...), f.extra...))
+}
+
+func (f *csvFormat) WriteLookup(lookup Lookup) error {
+	if !f.headerWritten {
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range lookup.Records {
+		err := f.w.Write(append([]string{
+			r.Query,
+			string(r.Type),
+			r.Name,
+			r.State,
+			r.StateCode,
+			r.Country,
+			r.Zip,
+			formatCoordinate(r.Lat),
+			formatCoordinate(r.Lon),
+		}, extraValues(r, f.extra)...))
+		if err != nil {
+			return err
+		}
+	}
+
+	f.w.Flush()
+	return f.w.Error()
+}
+
+func (f *csvFormat) Close() error {
+	if !f.headerWritten {
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+	f.w.Flush()
+	return f.w.Error()
+}
+
+// yamlFormat writes a sequence of records. Strings are emitted as JSON strings,
+// which are valid YAML double-quoted scalars, so no escaping rules of our own are needed.
+type yamlFormat struct {
+	w       io.Writer
+	written int
+}
+
+func (f *yamlFormat) WriteLookup(lookup Lookup) error {
+	for _, r := range lookup.Records {
+		fields := []yamlField{
+			{"query", yamlString(r.Query)},
+			{"type", yamlString(string(r.Type))},
+			{"name", yamlString(r.Name)},
+			{"state", yamlString(r.State)},
+			{"state_code", yamlString(r.StateCode)},
+			{"country", yamlString(r.Country)},
+			{"zip", yamlString(r.Zip)},
+			{"lat", formatCoordinate(r.Lat)},
+			{"lon", formatCoordinate(r.Lon)},
+		}
+		if len(r.BBox) == 4 {
+			fields = append(fields, yamlField{"bbox", fmt.Sprintf("[%s, %s, %s, %s]",
+				formatCoordinate(r.BBox[0]), formatCoordinate(r.BBox[1]), formatCoordinate(r.BBox[2]), formatCoordinate(r.BBox[3]))})
+		}
+		if r.OSMType != "" {
+			fields = append(fields, yamlField{"osm_type", yamlString(r.OSMType)}, yamlField{"osm_id", strconv.FormatInt(r.OSMID, 10)})
+		}
+		if r.TractFIPS != "" {
+			fields = append(fields, yamlField{"tract_fips", yamlString(r.TractFIPS)}, yamlField{"block_fips", yamlString(r.BlockFIPS)})
+		}
+		if r.Relaxed != "" {
+			fields = append(fields, yamlField{"relaxed", yamlString(r.Relaxed)}, yamlField{"precision", yamlString(string(r.Precision))})
+		}
+
+		for i, field := range fields {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			if _, err := fmt.Fprintf(f.w, "%s%s: %s\n", prefix, field.key, field.value); err != nil {
+				return err
+			}
+		}
+		f.written++
+	}
+	return nil
+}
+
+func (f *yamlFormat) Close() error {
+	if f.written == 0 {
+		_, err := fmt.Fprintln(f.w, "[]")
+		return err
+	}
+	return nil
+}
+
+// yamlField is a key of a record and its value, already formatted as YAML.
+type yamlField struct {
+	key   string
+	value string
+}
+
+func yamlString(s string) string {
+	b, _ := json.Marshal(s) // marshalling a string cannot fail
+	return string(b)
+}
+
+// writeXMLElement writes v as a single indented element on its own lines, for formats
+// that stream elements between a fixed document header and footer.
+func writeXMLElement(w io.Writer, prefix string, v any) error {
+	enc := xml.NewEncoder(w)
+	enc.Indent(prefix, "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// formatCoordinate prints a coordinate with no more precision than the API returned.
+func formatCoordinate(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,352 @@
+package cmd_test
+
+import (
+	"bytes"
+	. "github.com/MakeNowJust/heredoc/dot"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"testing"
+)
+
+var henricoLookup = internalcmd.NewNameLookup("Henrico, VA", internalcmd.LookupName, []internalcmd.NameResult{
+	{Name: "Henrico", Lat: 37.495702, Lon: -77.335257, Country: "US", State: "Virginia"},
+})
+
+var zipLookup = internalcmd.NewZipLookup("23228", &internalcmd.ZipResult{
+	Zip: "23228", Name: "Henrico County", Lat: 37.4638, Lon: -77.398, Country: "US",
+})
+
+var missedLookup = internalcmd.NewNameLookup("nowhere", internalcmd.LookupName, nil)
+
+// relaxedLookup found Henrico once "Short Pump, Henrico County, VA" was relaxed, see Relax.
+var relaxedLookup = func() internalcmd.Lookup {
+	lookup := internalcmd.NewLookup("Short Pump, Henrico County, VA", internalcmd.LookupName, []internalcmd.Place{
+		{Name: "Henrico", Lat: 37.495702, Lon: -77.335257, Country: "US", State: "Virginia"},
+	})
+	lookup.Relaxed, lookup.Precision = "Henrico, VA", internalcmd.PrecisionCity
+	lookup.Records[0].Relaxed, lookup.Records[0].Precision = "Henrico, VA", internalcmd.PrecisionCity
+	return lookup
+}()
+
+func TestLookup_First(t *testing.T) {
+	richmonds := internalcmd.NewNameLookup("Richmond", internalcmd.LookupName, []internalcmd.NameResult{
+		{Name: "Richmond", Lat: 37.5385, Lon: -77.4343, Country: "US", State: "Virginia"},
+		{Name: "Richmond", Lat: 37.9358, Lon: -122.3477, Country: "US", State: "California"},
+	})
+
+	tests := map[string]struct {
+		lookup   internalcmd.Lookup
+		expected internalcmd.Lookup
+	}{
+		"keeps the best match": {
+			lookup: richmonds,
+			expected: internalcmd.NewNameLookup("Richmond", internalcmd.LookupName, []internalcmd.NameResult{
+				{Name: "Richmond", Lat: 37.5385, Lon: -77.4343, Country: "US", State: "Virginia"},
+			}),
+		},
+		"a single match": {
+			lookup:   zipLookup,
+			expected: zipLookup,
+		},
+		"no matches": {
+			lookup:   missedLookup,
+			expected: missedLookup,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, tc.lookup.First()); diff != "" {
+				t.Errorf("First() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// censusLookup carries the census tract and block of an address, which only the Census geocoder reports.
+var censusLookup = internalcmd.NewLookup("4600 Silver Hill Rd, Washington, DC 20233", internalcmd.LookupName, []internalcmd.Place{{
+	Name: "4600 SILVER HILL RD, WASHINGTON, DC, 20233", State: "DC", Country: "US", Zip: "20233",
+	Lat: 38.846016, Lon: -76.927487, TractFIPS: "11001007601", BlockFIPS: "110010076011013",
+}})
+
+// osmLookup carries the OpenStreetMap element of a place, which only Nominatim reports.
+var osmLookup = internalcmd.NewLookup("Richmond, VA", internalcmd.LookupName, []internalcmd.Place{{
+	Name: "Richmond", State: "Virginia", Country: "US", Lat: 37.5385, Lon: -77.4343, OSMType: "relation", OSMID: 206629,
+}})
+
+func TestFormats(t *testing.T) {
+	tests := map[string]struct {
+		format   string
+		extra    []string
+		lookups  []internalcmd.Lookup
+		expected string
+	}{
+		"text": {
+			format:  "text",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				'Henrico, VA' results:
+				  Name: Henrico, Virginia, US
+				  Lat,Lon: 37.495702, -77.335257
+
+				'nowhere' results:
+				  No matches found.
+
+				'23228' results:
+				  Name: Henrico County, US, 23228
+				  Lat,Lon: 37.463800, -77.398000
+
+			`),
+		},
+		"text with a relaxed match": {
+			format:  "text",
+			lookups: []internalcmd.Lookup{relaxedLookup},
+			expected: D(`
+				'Short Pump, Henrico County, VA' results:
+				  No exact match, relaxed to 'Henrico, VA' (city precision).
+				  Name: Henrico, Virginia, US
+				  Lat,Lon: 37.495702, -77.335257
+
+			`),
+		},
+		"json": {
+			format:  "json",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				[
+				  {
+				    "query": "Henrico, VA",
+				    "type": "name",
+				    "name": "Henrico",
+				    "state": "Virginia",
+				    "state_code": "VA",
+				    "country": "US",
+				    "zip": "",
+				    "lat": 37.495702,
+				    "lon": -77.335257
+				  },
+				  {
+				    "query": "23228",
+				    "type": "zip",
+				    "name": "Henrico County",
+				    "state": "",
+				    "state_code": "",
+				    "country": "US",
+				    "zip": "23228",
+				    "lat": 37.4638,
+				    "lon": -77.398
+				  }
+				]
+			`),
+		},
+		"json without records is an empty array": {
+			format:   "json",
+			lookups:  []internalcmd.Lookup{missedLookup},
+			expected: "[]\n",
+		},
+		"ndjson": {
+			format:  "ndjson",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				{"query":"Henrico, VA","type":"name","name":"Henrico","state":"Virginia","state_code":"VA","country":"US","zip":"","lat":37.495702,"lon":-77.335257}
+				{"query":"23228","type":"zip","name":"Henrico County","state":"","state_code":"","country":"US","zip":"23228","lat":37.4638,"lon":-77.398}
+			`),
+		},
+		"ndjson with a relaxed match": {
+			format:  "ndjson",
+			lookups: []internalcmd.Lookup{relaxedLookup},
+			expected: D(`
+				{"query":"Short Pump, Henrico County, VA","type":"name","name":"Henrico","state":"Virginia","state_code":"VA","country":"US","zip":"","lat":37.495702,"lon":-77.335257,"relaxed":"Henrico, VA","precision":"city"}
+			`),
+		},
+		"csv": {
+			format:  "csv",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				query,type,name,state,state_code,country,zip,lat,lon
+				"Henrico, VA",name,Henrico,Virginia,VA,US,,37.495702,-77.335257
+				23228,zip,Henrico County,,,US,23228,37.4638,-77.398
+			`),
+		},
+		"csv without records is a header": {
+			format:   "csv",
+			expected: "query,type,name,state,state_code,country,zip,lat,lon\n",
+		},
+		"csv with the extra columns of the Census geocoder": {
+			format:  "csv",
+			extra:   internalcmd.CensusColumns,
+			lookups: []internalcmd.Lookup{censusLookup, zipLookup},
+			expected: D(`
+				query,type,name,state,state_code,country,zip,lat,lon,tract_fips,block_fips
+				"4600 Silver Hill Rd, Washington, DC 20233",name,"4600 SILVER HILL RD, WASHINGTON, DC, 20233",DC,DC,US,20233,38.846016,-76.927487,11001007601,110010076011013
+				23228,zip,Henrico County,,,US,23228,37.4638,-77.398,,
+			`),
+		},
+		"csv with the extra columns of Nominatim": {
+			format:  "csv",
+			extra:   internalcmd.OSMColumns,
+			lookups: []internalcmd.Lookup{osmLookup},
+			expected: D(`
+				query,type,name,state,state_code,country,zip,lat,lon,osm_type,osm_id
+				"Richmond, VA",name,Richmond,Virginia,VA,US,,37.5385,-77.4343,relation,206629
+			`),
+		},
+		"csv without records has the extra columns in its header": {
+			format:   "csv",
+			extra:    internalcmd.OSMColumns,
+			expected: "query,type,name,state,state_code,country,zip,lat,lon,osm_type,osm_id\n",
+		},
+		"yaml": {
+			format:  "yaml",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				- query: "Henrico, VA"
+				  type: "name"
+				  name: "Henrico"
+				  state: "Virginia"
+				  state_code: "VA"
+				  country: "US"
+				  zip: ""
+				  lat: 37.495702
+				  lon: -77.335257
+				- query: "23228"
+				  type: "zip"
+				  name: "Henrico County"
+				  state: ""
+				  state_code: ""
+				  country: "US"
+				  zip: "23228"
+				  lat: 37.4638
+				  lon: -77.398
+			`),
+		},
+		"yaml without records is an empty sequence": {
+			format:   "yaml",
+			lookups:  []internalcmd.Lookup{missedLookup},
+			expected: "[]\n",
+		},
+		"geojson": {
+			format:  "geojson",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				{"type":"FeatureCollection","features":[
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[-77.335257,37.495702]},"properties":{"name":"Henrico","state":"Virginia","state_code":"VA","country":"US","zip":"","query":"Henrico, VA","type":"name"}},
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[-77.398,37.4638]},"properties":{"name":"Henrico County","state":"","state_code":"","country":"US","zip":"23228","query":"23228","type":"zip"}}
+				]}
+			`),
+		},
+		"geojson without records is an empty collection": {
+			format:   "geojson",
+			lookups:  []internalcmd.Lookup{missedLookup},
+			expected: "{\"type\":\"FeatureCollection\",\"features\":[]}\n",
+		},
+		"kml gives each query its own icon color": {
+			format:  "kml",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				<?xml version="1.0" encoding="UTF-8"?>
+				<kml xmlns="http://www.opengis.net/kml/2.2">
+				  <Document>
+				    <name>geo</name>
+				    <Placemark>
+				      <name>Henrico, VA</name>
+				      <description>Henrico, Virginia, US</description>
+				      <Style>
+				        <IconStyle>
+				          <color>ff0000ff</color>
+				          <Icon>
+				            <href>https://maps.google.com/mapfiles/kml/pushpin/wht-pushpin.png</href>
+				          </Icon>
+				        </IconStyle>
+				      </Style>
+				      <Point>
+				        <coordinates>-77.335257,37.495702</coordinates>
+				      </Point>
+				    </Placemark>
+				    <Placemark>
+				      <name>23228</name>
+				      <description>Henrico County, US, 23228</description>
+				      <Style>
+				        <IconStyle>
+				          <color>ff00ff00</color>
+				          <Icon>
+				            <href>https://maps.google.com/mapfiles/kml/pushpin/wht-pushpin.png</href>
+				          </Icon>
+				        </IconStyle>
+				      </Style>
+				      <Point>
+				        <coordinates>-77.398,37.4638</coordinates>
+				      </Point>
+				    </Placemark>
+				  </Document>
+				</kml>
+			`),
+		},
+		"gpx": {
+			format:  "gpx",
+			lookups: []internalcmd.Lookup{henricoLookup, missedLookup, zipLookup},
+			expected: D(`
+				<?xml version="1.0" encoding="UTF-8"?>
+				<gpx version="1.1" creator="geo" xmlns="http://www.topografix.com/GPX/1/1">
+				  <wpt lat="37.495702" lon="-77.335257">
+				    <name>Henrico, VA</name>
+				    <desc>Henrico, Virginia, US</desc>
+				    <type>name</type>
+				  </wpt>
+				  <wpt lat="37.4638" lon="-77.398">
+				    <name>23228</name>
+				    <desc>Henrico County, US, 23228</desc>
+				    <type>zip</type>
+				  </wpt>
+				</gpx>
+			`),
+		},
+		"gpx escapes markup in queries": {
+			format: "gpx",
+			lookups: []internalcmd.Lookup{internalcmd.NewNameLookup("Fish & Chips <UK>", internalcmd.LookupName, []internalcmd.NameResult{
+				{Name: "Fish", Lat: 1, Lon: 2, Country: "GB"},
+			})},
+			expected: D(`
+				<?xml version="1.0" encoding="UTF-8"?>
+				<gpx version="1.1" creator="geo" xmlns="http://www.topografix.com/GPX/1/1">
+				  <wpt lat="1" lon="2">
+				    <name>Fish &amp; Chips &lt;UK&gt;</name>
+				    <desc>Fish, GB</desc>
+				    <type>name</type>
+				  </wpt>
+				</gpx>
+			`),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			out, err := internalcmd.NewFormat(tc.format, &buf, tc.extra)
+			if err != nil {
+				t.Fatalf("NewFormat() Unexpected error: %v", err)
+			}
+
+			for _, lookup := range tc.lookups {
+				if err := out.WriteLookup(lookup); err != nil {
+					t.Fatalf("WriteLookup() Unexpected error: %v", err)
+				}
+			}
+			if err := out.Close(); err != nil {
+				t.Fatalf("Close() Unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.expected, buf.String()); diff != "" {
+				t.Errorf("%s output mismatch (-want +got):\n%s", tc.format, diff)
+			}
+		})
+	}
+}
+
+func TestNewFormat_Unknown(t *testing.T) {
+	_, err := internalcmd.NewFormat("xml", &bytes.Buffer{}, nil)
+	expectedError := "unknown output format 'xml', expected one of: text, json, ndjson, csv, yaml, geojson, kml, gpx"
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf("NewFormat() Unexpected error: %v, expected %s", err, expectedError)
+	}
+}
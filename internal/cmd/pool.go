@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"iter"
+	"sync"
+)
+
+// Resolver looks up a single query, such as one returned by NewResolver.
+type Resolver func(ctx context.Context, query string) (Lookup, error)
+
+// Result is the outcome of resolving one query. A failed lookup keeps its error here,
+// rather than stopping the queries around it.
+type Result struct {
+	Lookup Lookup
+	Err    error
+}
+
+type indexedResult struct {
+	index  int
+	result Result
+}
+
+// ResolveAll resolves queries with up to parallel lookups in flight, yielding each result
+// with the index of its query. Results are yielded in input order as soon as every earlier
+// query has been resolved. Every query yields a result, even once ctx is done, and breaking
+// out of the loop cancels the lookups still in flight.
+func ResolveAll(ctx context.Context, resolve Resolver, queries []string, parallel int) iter.Seq2[int, Result] {
+	return func(yield func(int, Result) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// quit closes once the caller stops iterating, until then workers never drop a result.
+		quit := make(chan struct{})
+		defer close(quit)
+
+		parallel = max(1, min(parallel, len(queries)))
+		jobs := make(chan int)
+		done := make(chan indexedResult, parallel)
+
+		go func() {
+			defer close(jobs)
+			for i := range queries {
+				select {
+				case jobs <- i:
+				case <-quit:
+					return
+				}
+			}
+		}()
+
+		var workers sync.WaitGroup
+		for range parallel {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for i := range jobs {
+					lookup, err := resolve(ctx, queries[i])
+					select {
+					case done <- indexedResult{i, Result{Lookup: lookup, Err: err}}:
+					case <-quit:
+						return
+					}
+				}
+			}()
+		}
+
+		go func() {
+			workers.Wait()
+			close(done)
+		}()
+
+		// Results arrive in completion order, hold them back until their turn.
+		pending := map[int]Result{}
+		next := 0
+		for r := range done {
+			pending[r.index] = r.result
+			for {
+				result, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				if !yield(next, result) {
+					return
+				}
+				next++
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowResolver answers each query after a delay that shrinks along the input,
+// so later queries finish first. Queries starting with "fail" return an error.
+func slowResolver(inFlight, maxInFlight *atomic.Int32) internalcmd.Resolver {
+	return func(ctx context.Context, query string) (internalcmd.Lookup, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if n <= seen || maxInFlight.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+
+		var delay int
+		_, _ = fmt.Sscanf(query[len(query)-1:], "%d", &delay)
+		select {
+		case <-time.After(time.Duration(10-delay) * time.Millisecond):
+		case <-ctx.Done():
+			return internalcmd.Lookup{Query: query}, ctx.Err()
+		}
+
+		if query[:4] == "fail" {
+			return internalcmd.Lookup{Query: query}, errors.New("upstream failure")
+		}
+		return internalcmd.Lookup{Query: query}, nil
+	}
+}
+
+func TestResolveAll(t *testing.T) {
+	tests := map[string]struct {
+		parallel int
+	}{
+		"one worker":                {parallel: 1},
+		"several workers":           {parallel: 3},
+		"more workers than queries": {parallel: 20},
+	}
+
+	queries := []string{"ok-1", "fail-2", "ok-3", "ok-4", "fail-5", "ok-6", "ok-7", "ok-8", "ok-9"}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+
+			var got []string
+			for i, result := range internalcmd.ResolveAll(context.Background(), slowResolver(&inFlight, &maxInFlight), queries, tc.parallel) {
+				if result.Lookup.Query != queries[i] {
+					t.Fatalf("ResolveAll() result %d is for '%s', expected '%s'", i, result.Lookup.Query, queries[i])
+				}
+				status := "ok"
+				if result.Err != nil {
+					status = result.Err.Error()
+				}
+				got = append(got, fmt.Sprintf("%s: %s", result.Lookup.Query, status))
+			}
+
+			expected := []string{
+				"ok-1: ok", "fail-2: upstream failure", "ok-3: ok", "ok-4: ok", "fail-5: upstream failure",
+				"ok-6: ok", "ok-7: ok", "ok-8: ok", "ok-9: ok",
+			}
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Errorf("ResolveAll() mismatch (-want +got):\n%s", diff)
+			}
+
+			if limit := int32(min(tc.parallel, len(queries))); maxInFlight.Load() > limit {
+				t.Errorf("ResolveAll() ran %d lookups at once, expected at most %d", maxInFlight.Load(), limit)
+			}
+		})
+	}
+}
+
+func TestResolveAll_Break(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	queries := []string{"ok-1", "ok-2", "ok-3", "ok-4", "ok-5", "ok-6"}
+
+	yielded := 0
+	for range internalcmd.ResolveAll(context.Background(), slowResolver(&inFlight, &maxInFlight), queries, 2) {
+		yielded++
+		break
+	}
+
+	if yielded != 1 {
+		t.Fatalf("ResolveAll() yielded %d results after break, expected 1", yielded)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for inFlight.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ResolveAll() left %d lookups running after break", inFlight.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolveAll_Cancelled(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	queries := []string{"ok-1", "ok-2", "ok-3"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	yielded := 0
+	for _, result := range internalcmd.ResolveAll(ctx, slowResolver(&inFlight, &maxInFlight), queries, 2) {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("ResolveAll() Expected context.Canceled for '%s', got: %v", result.Lookup.Query, result.Err)
+		}
+		yielded++
+	}
+
+	if yielded != len(queries) {
+		t.Fatalf("ResolveAll() yielded %d results, expected one for each of the %d queries", yielded, len(queries))
+	}
+}
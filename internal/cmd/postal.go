@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PostalCode is a zip or postal code, along with its ISO 3166 alpha-2 country when known.
+type PostalCode struct {
+	Code    string
+	Country string // empty leaves the country up to the API, which assumes the US
+}
+
+// String returns the code in the "<code>,<country>" form of the OpenWeather zip endpoint.
+func (p PostalCode) String() string {
+	if p.Country == "" {
+		return p.Code
+	}
+	return p.Code + "," + p.Country
+}
+
+// postalRule recognises the postal codes of one country.
+type postalRule struct {
+	pattern *regexp.Regexp // matched against the upper case code, with runs of spaces collapsed
+	short   func(code string) string
+}
+
+// postalRules are the postal code formats geo knows, by ISO 3166 alpha-2 country.
+// see https://en.wikipedia.org/wiki/List_of_postal_codes
+var postalRules = map[string]postalRule{
+	"AT": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"AU": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"BE": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"BR": {pattern: regexp.MustCompile(`^\d{5}-?\d{3}$`)},
+	"CA": {pattern: regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[A-Z] ?\d[A-Z]\d$`)},
+	"CH": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"DE": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"DK": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"ES": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	// OpenWeather only knows the outward code of a UK postcode, the part before the space.
+	"GB": {pattern: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`), short: func(code string) string {
+		code = strings.ReplaceAll(code, " ", "")
+		return code[:len(code)-3]
+	}},
+	"IE": {pattern: regexp.MustCompile(`^[A-Z]\d[\dW] ?[\dA-Z]{4}$`)},
+	"IN": {pattern: regexp.MustCompile(`^\d{6}$`)},
+	"IT": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"JP": {pattern: regexp.MustCompile(`^\d{3}-?\d{4}$`)},
+	"MX": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {pattern: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+	"NO": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"NZ": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"PL": {pattern: regexp.MustCompile(`^\d{2}-\d{3}$`)},
+	"PT": {pattern: regexp.MustCompile(`^\d{4}-\d{3}$`)},
+	"SE": {pattern: regexp.MustCompile(`^\d{3} ?\d{2}$`)},
+	// OpenWeather does not know ZIP+4 codes, only the five digit ZIP code they extend.
+	"US": {pattern: regexp.MustCompile(`^\d{5}(-\d{4})?$`), short: func(code string) string {
+		return code[:5]
+	}},
+}
+
+// postalDetectOrder lists the countries whose postal codes are recognised without a country.
+// Formats shared by several countries, such as four or five digits, are left to the first country using them.
+var postalDetectOrder = []string{"US", "CA", "GB", "NL", "IE", "BR", "JP", "PT", "PL"}
+
+// ParsePostalCode recognises query as a postal code, either on its own or qualified with a country as
+// "<code>,<country>", the country being a code or name known to CountryByName. A code without a country is checked
+// against the formats of defaultCountry when it is set, then against the formats recognisable on their own.
+// Numeric queries are always postal codes, so they keep using the zip endpoint as they always have.
+func ParsePostalCode(query, defaultCountry string) (PostalCode, bool) {
+	code := strings.ToUpper(strings.Join(strings.Fields(query), " "))
+
+	if i := strings.LastIndex(code, ","); i >= 0 {
+		c, ok := CountryByName(code[i+1:])
+		rule, known := postalRules[c.Alpha2]
+		code = strings.TrimSpace(code[:i])
+		if !ok || !known || !rule.pattern.MatchString(code) {
+			return PostalCode{}, false
+		}
+		return rule.postalCode(code, c.Alpha2), true
+	}
+
+	if defaultCountry != "" {
+		defaultCountry = strings.ToUpper(defaultCountry)
+		if rule, known := postalRules[defaultCountry]; known && rule.pattern.MatchString(code) {
+			return rule.postalCode(code, defaultCountry), true
+		}
+		if isDigits(code) {
+			return PostalCode{Code: code, Country: defaultCountry}, true
+		}
+	}
+
+	for _, country := range postalDetectOrder {
+		if rule := postalRules[country]; rule.pattern.MatchString(code) {
+			return rule.postalCode(code, country), true
+		}
+	}
+
+	if isDigits(code) {
+		return PostalCode{Code: code}, true
+	}
+	return PostalCode{}, false
+}
+
+func (r postalRule) postalCode(code, country string) PostalCode {
+	if r.short != nil {
+		code = r.short(code)
+	}
+	return PostalCode{Code: code, Country: country}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package cmd_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"testing"
+)
+
+func TestParsePostalCode(t *testing.T) {
+	tests := map[string]struct {
+		query          string
+		defaultCountry string
+		expected       internalcmd.PostalCode
+		expectNotFound bool
+	}{
+		"US ZIP code": {
+			query:    "23228",
+			expected: internalcmd.PostalCode{Code: "23228", Country: "US"},
+		},
+		"US ZIP+4 code is shortened": {
+			query:    "23228-1234",
+			expected: internalcmd.PostalCode{Code: "23228", Country: "US"},
+		},
+		"Canadian postal code": {
+			query:    "k1a 0b6",
+			expected: internalcmd.PostalCode{Code: "K1A 0B6", Country: "CA"},
+		},
+		"UK postcode is shortened to its outward code": {
+			query:    "SW1A  1AA",
+			expected: internalcmd.PostalCode{Code: "SW1A", Country: "GB"},
+		},
+		"Dutch postcode": {
+			query:    "1012 AB",
+			expected: internalcmd.PostalCode{Code: "1012 AB", Country: "NL"},
+		},
+		"Japanese postal code": {
+			query:    "100-0001",
+			expected: internalcmd.PostalCode{Code: "100-0001", Country: "JP"},
+		},
+		"code qualified by country": {
+			query:    "10115, de",
+			expected: internalcmd.PostalCode{Code: "10115", Country: "DE"},
+		},
+		"code qualified by country name": {
+			query:    "10115, Germany",
+			expected: internalcmd.PostalCode{Code: "10115", Country: "DE"},
+		},
+		"qualified code is checked against the country": {
+			query:          "1012 AB,DE",
+			expectNotFound: true,
+		},
+		"qualified code in an unknown country": {
+			query:          "12345,XX",
+			expectNotFound: true,
+		},
+		"default country decides shared formats": {
+			query:          "75001",
+			defaultCountry: "fr",
+			expected:       internalcmd.PostalCode{Code: "75001", Country: "FR"},
+		},
+		"default country does not claim other formats": {
+			query:          "K1A 0B6",
+			defaultCountry: "FR",
+			expected:       internalcmd.PostalCode{Code: "K1A 0B6", Country: "CA"},
+		},
+		"numeric query in a default country without known formats": {
+			query:          "2000",
+			defaultCountry: "za",
+			expected:       internalcmd.PostalCode{Code: "2000", Country: "ZA"},
+		},
+		"numeric query without a known format": {
+			query:    "123",
+			expected: internalcmd.PostalCode{Code: "123"},
+		},
+		"place name": {
+			query:          "Henrico, VA",
+			expectNotFound: true,
+		},
+		"place name with a country": {
+			query:          "Paris, FR",
+			expectNotFound: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			postal, ok := internalcmd.ParsePostalCode(tc.query, tc.defaultCountry)
+			if ok == tc.expectNotFound {
+				t.Fatalf("ParsePostalCode() recognised a postal code: %t, expected %t", ok, !tc.expectNotFound)
+			}
+			if diff := cmp.Diff(tc.expected, postal); diff != "" {
+				t.Errorf("ParsePostalCode() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that spaces out API calls to stay within an OpenWeather quota.
+// One limiter is meant to be shared by every lookup made with a key, including parallel ones.
+// see https://openweathermap.org/price
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time to earn one call
+	burst    float64       // calls that can be made back to back after a quiet spell
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimiter allows perMinute calls each minute, with up to burst of them made at once.
+// perMinute must be at least 1, a burst below 1 is treated as 1.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	burst = max(1, burst)
+	return &RateLimiter{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a call is allowed, or returns the error of ctx if it is done first. A nil limiter never waits.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, going into debt when none are left, and returns how long
+// the caller must wait for the token it took. Callers are served in the order they reserve.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens * float64(l.interval))
+}
+
+// cancel returns a token taken by a caller that gave up waiting.
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+1)
+}
+
+// pacer spaces out the requests of a provider, such as a RateLimiter.
+type pacer interface {
+	Wait(ctx context.Context) error
+}
+
+// rateLimiters paces requests with every one of its limiters in turn, for servers whose usage policy applies
+// on top of the user's own limit, such as the public Nominatim server.
+type rateLimiters []*RateLimiter
+
+func (ls rateLimiters) Wait(ctx context.Context) error {
+	for _, l := range ls {
+		if err := l.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	// 20ms per call, so the timings below are comfortably larger than scheduler noise.
+	limiter := internalcmd.NewRateLimiter(3000, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 15*time.Millisecond {
+		t.Fatalf("Wait() took %s for the burst, expected no delay", elapsed)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Fatalf("Wait() took %s for 3 calls past the burst, expected at least 60ms", elapsed)
+	}
+}
+
+func TestRateLimiter_Shared(t *testing.T) {
+	limiter := internalcmd.NewRateLimiter(3000, 1)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = limiter.Wait(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Fatalf("Wait() let 5 concurrent calls through in %s, expected at least 80ms", elapsed)
+	}
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+	limiter := internalcmd.NewRateLimiter(1, 1)
+	_ = limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() Expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func TestDirectGeocoding_Limiter(t *testing.T) {
+	transport := &stubTransport{StatusCode: http.StatusOK, Body: `[]`}
+	g := internalcmd.DirectGeocoding{
+		Key:     "test-key",
+		Client:  &http.Client{Transport: transport},
+		Limiter: internalcmd.NewRateLimiter(1, 1),
+	}
+
+	if _, err := g.LocationByName("Henrico, VA"); err != nil {
+		t.Fatalf("LocationByName() Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := g.LocationByNameContext(ctx, "Henrico, VA"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LocationByNameContext() Expected the limiter to hold the call past its deadline, got: %v", err)
+	}
+	if len(transport.Requests) != 1 {
+		t.Fatalf("Expected the limiter to allow 1 request, %d were made", len(transport.Requests))
+	}
+}
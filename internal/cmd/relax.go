@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Precision says how coarse a match found by relaxing a query is.
+type Precision string
+
+const (
+	PrecisionLocality Precision = "locality" // the street or county was dropped, the match is the town or neighbourhood named
+	PrecisionCity     Precision = "city"     // the neighbourhood was dropped too, the match is the city or county around it
+)
+
+// Relaxation is a simpler form of a place name, tried when the name as given matches nothing.
+type Relaxation struct {
+	Query     string
+	Precision Precision
+}
+
+var (
+	// streetPattern matches the parts of an address before the place: a house number and street,
+	// a unit such as "Apt 4" or "#12", or a post office box.
+	streetPattern = regexp.MustCompile(`(?i)^(\d+[a-z]?(-\d+)?\s+\S|(apt|apartment|suite|unit|bldg|building|floor|fl|room|rm)\.?\s*#?\s*\w+$|#\s*\w+$|p\.?\s*o\.?\s+box\b)`)
+	// countyPattern matches a county, parish or borough, such as "Henrico County", capturing its name.
+	countyPattern = regexp.MustCompile(`(?i)^(.+?)\s+(county|co\.?|parish|borough)$`)
+)
+
+// Relaxations returns simpler forms of a place name, split on commas, to try in turn when it matches nothing.
+// Each form drops more than the one before it: the street, such as "123 Main St" or "Apt 4", then also any county,
+// then also the neighbourhood, the first place left, when a city follows it. A county named after the
+// neighbourhood stands in for the city, so "Short Pump, Henrico County, VA" relaxes to "Short Pump, VA", then
+// "Henrico, VA". The region, country and ZIP code ending the name are kept, as is the last place, so a name
+// never relaxes to a whole state or country. A name with nothing to drop has no relaxations.
+func Relaxations(name string) []Relaxation {
+	var parts []string
+	for _, part := range strings.Split(name, ",") {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	places, tail, inState := splitRegion(parts)
+
+	var relaxations []Relaxation
+	add := func(places []string, precision Precision) {
+		if len(places) == 0 {
+			return
+		}
+		query := strings.Join(append(append([]string{}, places...), tail...), ", ")
+		if normalizeQuery(query) == normalizeQuery(name) {
+			return
+		}
+		for _, r := range relaxations {
+			if normalizeQuery(r.Query) == normalizeQuery(query) {
+				return
+			}
+		}
+		relaxations = append(relaxations, Relaxation{Query: query, Precision: precision})
+	}
+
+	for len(places) > 1 && streetPattern.MatchString(places[0]) {
+		places = places[1:]
+	}
+	add(places, PrecisionLocality)
+
+	var withoutCounty []string
+	for _, place := range places {
+		if !countyPattern.MatchString(place) {
+			withoutCounty = append(withoutCounty, place)
+		}
+	}
+	if len(withoutCounty) == 0 {
+		withoutCounty = withoutCountySuffix(places) // the county is the only place, so it is kept by name
+	}
+	add(withoutCounty, PrecisionLocality)
+
+	// Only a state or a third place says the second place is a city rather than the region itself.
+	if len(places) > 2 || (len(places) == 2 && inState) {
+		add(withoutCountySuffix(places[1:]), PrecisionCity)
+	}
+	return relaxations
+}
+
+// withoutCountySuffix names each county among places without its suffix, so "Henrico County" is "Henrico".
+func withoutCountySuffix(places []string) []string {
+	simplified := make([]string, len(places))
+	for i, place := range places {
+		simplified[i] = countyPattern.ReplaceAllString(place, "$1")
+	}
+	return simplified
+}
+
+// splitRegion splits the parts of a place name into its places and the US state, ZIP code and country ending it,
+// reporting whether a US state was among them. The first part is always a place.
+func splitRegion(parts []string) ([]string, []string, bool) {
+	end := len(parts)
+	inState := false
+	for end > 1 && len(parts)-end < 3 {
+		last := parts[end-1]
+		if i := strings.LastIndex(last, " "); zipPattern.MatchString(last[i+1:]) {
+			last = strings.TrimSpace(last[:max(i, 0)])
+		}
+		if _, ok := USStateByName(last); ok {
+			end--
+			inState = true
+			break // a state is preceded by its city, even one named like a state, such as "Washington, DC"
+		}
+		if _, ok := CountryByName(last); !ok && last != "" {
+			break
+		}
+		end--
+	}
+	return parts[:end], parts[end:], inState
+}
+
+// Relax returns a Resolver that, when resolve matches a place name with nothing, tries its Relaxations in turn
+// until one matches. The records of a relaxed lookup keep the query as given, along with the form that matched
+// and its Precision. Postal codes and failed lookups are returned as they are.
+func Relax(resolve Resolver) Resolver {
+	return func(ctx context.Context, query string) (Lookup, error) {
+		lookup, err := resolve(ctx, query)
+		if err != nil || lookup.Type != LookupName || len(lookup.Records) > 0 {
+			return lookup, err
+		}
+
+		for _, r := range Relaxations(query) {
+			relaxed, err := resolve(ctx, r.Query)
+			if err != nil {
+				return Lookup{Query: query, Type: LookupName}, err
+			}
+			if len(relaxed.Records) > 0 {
+				return relaxed.relaxedFrom(query, r), nil
+			}
+		}
+		return lookup, nil
+	}
+}
+
+// relaxedFrom returns a copy of a lookup of r attributed to the query it relaxes.
+func (l Lookup) relaxedFrom(query string, r Relaxation) Lookup {
+	l = l.withQuery(query)
+	l.Relaxed, l.Precision = r.Query, r.Precision
+	for i := range l.Records {
+		l.Records[i].Relaxed, l.Records[i].Precision = r.Query, r.Precision
+	}
+	return l
+}
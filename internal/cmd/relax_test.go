@@ -0,0 +1,152 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"testing"
+)
+
+func TestRelaxations(t *testing.T) {
+	tests := map[string]struct {
+		name     string
+		expected []internalcmd.Relaxation
+	}{
+		"street": {
+			name:     "123 Main St, Richmond, VA",
+			expected: []internalcmd.Relaxation{{Query: "Richmond, VA", Precision: internalcmd.PrecisionLocality}},
+		},
+		"street and unit": {
+			name:     "123 Main St, Apt 4, Richmond, VA 23220",
+			expected: []internalcmd.Relaxation{{Query: "Richmond, VA 23220", Precision: internalcmd.PrecisionLocality}},
+		},
+		"county, then neighbourhood": {
+			name: "Short Pump, Henrico County, VA",
+			expected: []internalcmd.Relaxation{
+				{Query: "Short Pump, VA", Precision: internalcmd.PrecisionLocality},
+				{Query: "Henrico, VA", Precision: internalcmd.PrecisionCity},
+			},
+		},
+		"street, county, then neighbourhood": {
+			name: "12000 W Broad St, Short Pump, Henrico County, Virginia, USA",
+			expected: []internalcmd.Relaxation{
+				{Query: "Short Pump, Henrico County, Virginia, USA", Precision: internalcmd.PrecisionLocality},
+				{Query: "Short Pump, Virginia, USA", Precision: internalcmd.PrecisionLocality},
+				{Query: "Henrico, Virginia, USA", Precision: internalcmd.PrecisionCity},
+			},
+		},
+		"neighbourhood": {
+			name:     "The Fan, Richmond, VA",
+			expected: []internalcmd.Relaxation{{Query: "Richmond, VA", Precision: internalcmd.PrecisionCity}},
+		},
+		"neighbourhood outside the US": {
+			name:     "Montmartre, Paris, Ile-de-France, France",
+			expected: []internalcmd.Relaxation{{Query: "Paris, Ile-de-France, France", Precision: internalcmd.PrecisionCity}},
+		},
+		"only a county": {
+			name:     "Henrico County, VA",
+			expected: []internalcmd.Relaxation{{Query: "Henrico, VA", Precision: internalcmd.PrecisionLocality}},
+		},
+		"city named like a state": {
+			name:     "4600 Silver Hill Rd, Washington, DC",
+			expected: []internalcmd.Relaxation{{Query: "Washington, DC", Precision: internalcmd.PrecisionLocality}},
+		},
+		"region outside the US": {
+			name: "Paris, Ile-de-France, France",
+		},
+		"nothing to drop": {
+			name: "Richmond, VA",
+		},
+		"only a street": {
+			name: "123 Main St, VA",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, internalcmd.Relaxations(tc.name)); diff != "" {
+				t.Errorf("Relaxations() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRelax(t *testing.T) {
+	geocoder := &fakeGeocoder{names: map[string][]internalcmd.Place{
+		"Henrico, VA": {henricoPlace},
+		"Richmond, VA": {
+			{Name: "Richmond", State: "Virginia", Country: "US", Lat: 37.5385, Lon: -77.4343},
+		},
+	}}
+	resolve := internalcmd.Relax(internalcmd.NewResolver(geocoder, "US"))
+
+	henricoRelaxed := internalcmd.NewLookup("Short Pump, Henrico County, VA", internalcmd.LookupName, []internalcmd.Place{henricoPlace})
+	henricoRelaxed.Relaxed, henricoRelaxed.Precision = "Henrico, VA", internalcmd.PrecisionCity
+	henricoRelaxed.Records[0].Relaxed, henricoRelaxed.Records[0].Precision = "Henrico, VA", internalcmd.PrecisionCity
+
+	tests := map[string]struct {
+		query            string
+		expectedSearches []string
+		expected         internalcmd.Lookup
+	}{
+		"a match is not relaxed": {
+			query:            "Henrico, VA",
+			expectedSearches: []string{"name:Henrico, VA"},
+			expected:         henricoLookup,
+		},
+		"relaxed until a form matches": {
+			query: "Short Pump, Henrico County, VA",
+			expectedSearches: []string{
+				"name:Short Pump, Henrico County, VA",
+				"name:Short Pump, VA",
+				"name:Henrico, VA",
+			},
+			expected: henricoRelaxed,
+		},
+		"no form matches": {
+			query:            "1 Nowhere Ln, Nowhere, ZZ",
+			expectedSearches: []string{"name:1 Nowhere Ln, Nowhere, ZZ", "name:Nowhere, ZZ"},
+			expected:         internalcmd.NewLookup("1 Nowhere Ln, Nowhere, ZZ", internalcmd.LookupName, nil),
+		},
+		"postal codes are not relaxed": {
+			query:            "23228",
+			expectedSearches: []string{"postal:23228,US"},
+			expected:         internalcmd.NewLookup("23228", internalcmd.LookupZip, nil),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			geocoder.searches = nil
+			lookup, err := resolve(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("Relax() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedSearches, geocoder.searches); diff != "" {
+				t.Errorf("Relax() searches mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expected, lookup); diff != "" {
+				t.Errorf("Relax() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRelax_Error(t *testing.T) {
+	failed := &internalcmd.APIError{StatusCode: 502, Err: internalcmd.ErrUpstream}
+	resolve := internalcmd.Relax(func(ctx context.Context, query string) (internalcmd.Lookup, error) {
+		if query == "Richmond, VA" {
+			return internalcmd.Lookup{Query: query, Type: internalcmd.LookupName}, failed
+		}
+		return internalcmd.NewLookup(query, internalcmd.LookupName, nil), nil
+	})
+
+	lookup, err := resolve(context.Background(), "123 Main St, Richmond, VA")
+	if !errors.Is(err, internalcmd.ErrUpstream) {
+		t.Errorf("Relax() Unexpected error: %v, expected %v", err, internalcmd.ErrUpstream)
+	}
+	if lookup.Query != "123 Main St, Richmond, VA" {
+		t.Errorf("Relax() Unexpected query: %s, expected the query as given", lookup.Query)
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+)
+
+// NewResolver returns a Resolver of free-form queries to geocoder. Postal codes, as recognised by ParsePostalCode
+// with defaultCountry, are looked up with SearchPostalCode, and anything else with Search.
+// A query the geocoder does not recognise is a Lookup without records, not an error.
+func NewResolver(geocoder Geocoder, defaultCountry string) Resolver {
+	return func(ctx context.Context, query string) (Lookup, error) {
+		if postal, ok := ParsePostalCode(query, defaultCountry); ok {
+			places, err := geocoder.SearchPostalCode(ctx, postal)
+			if err != nil {
+				return Lookup{Query: query, Type: LookupZip}, err
+			}
+			return NewLookup(query, LookupZip, places), nil
+		}
+
+		// not a postal code, use name
+		places, err := geocoder.Search(ctx, query)
+		if err != nil {
+			return Lookup{Query: query, Type: LookupName}, err
+		}
+		return NewLookup(query, LookupName, places), nil
+	}
+}
+
+// NewBatchResolver searches for every name among queries at once with geocoder.SearchBatch, then returns a Resolver
+// answering those queries from the results, and any other, such as postal codes, as NewResolver does.
+// When the batch fails, each of its queries fails with the same error.
+func NewBatchResolver(ctx context.Context, geocoder BatchGeocoder, queries []string, defaultCountry string) Resolver {
+	var names []string
+	batched := map[string][]Place{}
+	for _, query := range queries {
+		if _, ok := ParsePostalCode(query, defaultCountry); ok || query == "" {
+			continue
+		}
+		if _, seen := batched[query]; !seen {
+			batched[query] = nil
+			names = append(names, query)
+		}
+	}
+
+	var err error
+	if len(names) > 0 {
+		var found [][]Place
+		if found, err = geocoder.SearchBatch(ctx, names); err == nil {
+			for i, name := range names {
+				batched[name] = found[i]
+			}
+		}
+	}
+
+	resolve := NewResolver(geocoder, defaultCountry)
+	return func(ctx context.Context, query string) (Lookup, error) {
+		places, ok := batched[query]
+		if !ok {
+			return resolve(ctx, query)
+		}
+		if err != nil {
+			return Lookup{Query: query, Type: LookupName}, err
+		}
+		return NewLookup(query, LookupName, places), nil
+	}
+}
+
+// ResolveCoordinates looks up the places nearest to lat and lon with geocoder, recording query as the source of each record.
+func ResolveCoordinates(ctx context.Context, geocoder Geocoder, query string, lat, lon float64, limit int) (Lookup, error) {
+	places, err := geocoder.Reverse(ctx, lat, lon, limit)
+	if err != nil {
+		return Lookup{Query: query, Type: LookupReverse}, err
+	}
+	return NewLookup(query, LookupReverse, places), nil
+}
+
+// Resolve looks up a free-form query as NewResolver does, with Country as the default country of postal codes.
+func (g *DirectGeocoding) Resolve(ctx context.Context, query string) (Lookup, error) {
+	return NewResolver(g, g.Country)(ctx, query)
+}
+
+// ResolveCoordinates looks up the places nearest to lat and lon, recording query as the source of each record.
+func (g *DirectGeocoding) ResolveCoordinates(ctx context.Context, query string, lat, lon float64, limit int) (Lookup, error) {
+	return ResolveCoordinates(ctx, g, query, lat, lon, limit)
+}
+
+// Search implements Geocoder with LocationByNameContext, naming places in Lang.
+func (g *DirectGeocoding) Search(ctx context.Context, name string) ([]Place, error) {
+	locations, err := g.LocationByNameContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return namePlaces(locations, g.Lang), nil
+}
+
+// SearchPostalCode implements Geocoder with LocationByZipContext.
+func (g *DirectGeocoding) SearchPostalCode(ctx context.Context, postal PostalCode) ([]Place, error) {
+	loc, err := g.LocationByZipContext(ctx, postal.Code, postal.Country)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Place{loc.place()}, nil
+}
+
+// Reverse implements Geocoder with LocationByCoordinatesContext, naming places in Lang.
+func (g *DirectGeocoding) Reverse(ctx context.Context, lat, lon float64, limit int) ([]Place, error) {
+	locations, err := g.LocationByCoordinatesContext(ctx, lat, lon, limit)
+	if err != nil {
+		return nil, err
+	}
+	return namePlaces(locations, g.Lang), nil
+}
+
+// namePlaces converts named locations to places, naming each in lang, see NameResult.LocalName.
+func namePlaces(locations []NameResult, lang string) []Place {
+	var places []Place
+	for _, loc := range locations {
+		places = append(places, Place{
+			Name:    loc.LocalName(lang),
+			State:   loc.State,
+			Country: loc.Country,
+			Lat:     loc.Lat,
+			Lon:     loc.Lon,
+		})
+	}
+	return places
+}
+
+func (r *ZipResult) place() Place {
+	return Place{Name: r.Name, Country: r.Country, Zip: r.Zip, Lat: r.Lat, Lon: r.Lon}
+}
@@ -0,0 +1,200 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"strings"
+	"testing"
+)
+
+// fakeGeocoder answers from fixed places, recording the searches that reach it.
+type fakeGeocoder struct {
+	names    map[string][]internalcmd.Place
+	postals  map[internalcmd.PostalCode][]internalcmd.Place
+	reverse  []internalcmd.Place
+	err      error
+	searches []string
+}
+
+func (f *fakeGeocoder) Search(ctx context.Context, name string) ([]internalcmd.Place, error) {
+	f.searches = append(f.searches, "name:"+name)
+	return f.names[name], f.err
+}
+
+func (f *fakeGeocoder) SearchPostalCode(ctx context.Context, postal internalcmd.PostalCode) ([]internalcmd.Place, error) {
+	f.searches = append(f.searches, "postal:"+postal.String())
+	return f.postals[postal], f.err
+}
+
+func (f *fakeGeocoder) Reverse(ctx context.Context, lat, lon float64, limit int) ([]internalcmd.Place, error) {
+	f.searches = append(f.searches, "reverse")
+	if limit > 0 && limit < len(f.reverse) {
+		return f.reverse[:limit], f.err
+	}
+	return f.reverse, f.err
+}
+
+var henricoPlace = internalcmd.Place{Name: "Henrico", State: "Virginia", Country: "US", Lat: 37.495702, Lon: -77.335257}
+
+func TestNewResolver(t *testing.T) {
+	geocoder := &fakeGeocoder{
+		names: map[string][]internalcmd.Place{"Henrico, VA": {henricoPlace}},
+		postals: map[internalcmd.PostalCode][]internalcmd.Place{
+			{Code: "23228", Country: "US"}: {{Name: "Henrico County", Country: "US", Zip: "23228", Lat: 37.4638, Lon: -77.398}},
+		},
+	}
+
+	tests := map[string]struct {
+		query            string
+		defaultCountry   string
+		expectedSearches []string
+		expected         internalcmd.Lookup
+	}{
+		"names are searched by name": {
+			query:            "Henrico, VA",
+			defaultCountry:   "US",
+			expectedSearches: []string{"name:Henrico, VA"},
+			expected:         henricoLookup,
+		},
+		"postal codes are searched by postal code": {
+			query:            "23228",
+			defaultCountry:   "US",
+			expectedSearches: []string{"postal:23228,US"},
+			expected:         zipLookup,
+		},
+		"postal codes take the default country": {
+			query:            "75001",
+			defaultCountry:   "FR",
+			expectedSearches: []string{"postal:75001,FR"},
+			expected:         internalcmd.Lookup{Query: "75001", Type: internalcmd.LookupZip},
+		},
+		"unknown names match nothing": {
+			query:            "nowhere",
+			expectedSearches: []string{"name:nowhere"},
+			expected:         missedLookup,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			geocoder.searches = nil
+			lookup, err := internalcmd.NewResolver(geocoder, tc.defaultCountry)(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("Resolver() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedSearches, geocoder.searches); diff != "" {
+				t.Errorf("Resolver() searches mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expected, lookup); diff != "" {
+				t.Errorf("Resolver() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewResolver_Error(t *testing.T) {
+	geocoder := &fakeGeocoder{err: internalcmd.ErrUpstream}
+
+	lookup, err := internalcmd.NewResolver(geocoder, "US")(context.Background(), "Henrico, VA")
+	if !errors.Is(err, internalcmd.ErrUpstream) {
+		t.Fatalf("Resolver() Unexpected error: %v, expected %v", err, internalcmd.ErrUpstream)
+	}
+	if diff := cmp.Diff(internalcmd.Lookup{Query: "Henrico, VA", Type: internalcmd.LookupName}, lookup); diff != "" {
+		t.Errorf("Resolver() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveCoordinates(t *testing.T) {
+	geocoder := &fakeGeocoder{reverse: []internalcmd.Place{
+		henricoPlace,
+		{Name: "Richmond", State: "Virginia", Country: "US", Lat: 37.5385087, Lon: -77.43428},
+	}}
+
+	lookup, err := internalcmd.ResolveCoordinates(context.Background(), geocoder, "37.5,-77.4", 37.5, -77.4, 1)
+	if err != nil {
+		t.Fatalf("ResolveCoordinates() Unexpected error: %v", err)
+	}
+
+	expected := internalcmd.NewLookup("37.5,-77.4", internalcmd.LookupReverse, []internalcmd.Place{henricoPlace})
+	if diff := cmp.Diff(expected, lookup); diff != "" {
+		t.Errorf("ResolveCoordinates() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fakeBatchGeocoder is a fakeGeocoder that also searches in batches.
+type fakeBatchGeocoder struct {
+	fakeGeocoder
+}
+
+func (f *fakeBatchGeocoder) SearchBatch(ctx context.Context, names []string) ([][]internalcmd.Place, error) {
+	f.searches = append(f.searches, "batch:"+strings.Join(names, "|"))
+	found := make([][]internalcmd.Place, len(names))
+	for i, name := range names {
+		found[i] = f.names[name]
+	}
+	return found, f.err
+}
+
+func TestNewBatchResolver(t *testing.T) {
+	geocoder := &fakeBatchGeocoder{fakeGeocoder{
+		names: map[string][]internalcmd.Place{"Henrico, VA": {henricoPlace}},
+	}}
+
+	resolve := internalcmd.NewBatchResolver(context.Background(), geocoder, []string{"Henrico, VA", "23228", "", "nowhere", "Henrico, VA"}, "US")
+
+	tests := map[string]struct {
+		query            string
+		expectedSearches []string
+		expected         internalcmd.Lookup
+	}{
+		"batched names are answered from the batch": {
+			query:    "Henrico, VA",
+			expected: henricoLookup,
+		},
+		"batched names without matches match nothing": {
+			query:    "nowhere",
+			expected: missedLookup,
+		},
+		"postal codes are searched on their own": {
+			query:            "23228",
+			expectedSearches: []string{"postal:23228,US"},
+			expected:         internalcmd.Lookup{Query: "23228", Type: internalcmd.LookupZip},
+		},
+		"names outside the batch are searched on their own": {
+			query:            "Richmond, VA",
+			expectedSearches: []string{"name:Richmond, VA"},
+			expected:         internalcmd.Lookup{Query: "Richmond, VA", Type: internalcmd.LookupName},
+		},
+	}
+
+	if diff := cmp.Diff([]string{"batch:Henrico, VA|nowhere"}, geocoder.searches); diff != "" {
+		t.Errorf("NewBatchResolver() searches mismatch (-want +got):\n%s", diff)
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			geocoder.searches = nil
+			lookup, err := resolve(context.Background(), tc.query)
+			if err != nil {
+				t.Fatalf("Resolver() Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedSearches, geocoder.searches); diff != "" {
+				t.Errorf("Resolver() searches mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.expected, lookup); diff != "" {
+				t.Errorf("Resolver() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewBatchResolver_Error(t *testing.T) {
+	geocoder := &fakeBatchGeocoder{fakeGeocoder{err: internalcmd.ErrUpstream}}
+
+	resolve := internalcmd.NewBatchResolver(context.Background(), geocoder, []string{"Henrico, VA"}, "US")
+	if _, err := resolve(context.Background(), "Henrico, VA"); !errors.Is(err, internalcmd.ErrUpstream) {
+		t.Errorf("Resolver() Unexpected error: %v, expected %v", err, internalcmd.ErrUpstream)
+	}
+}
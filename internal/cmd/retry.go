@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries API calls that failed for reasons likely to pass, such as rate limiting,
+// an overloaded server or a dropped connection. Only idempotent requests are retried: every lookup is a GET, other
+// than the Census batch upload, a POST only because its file is too large for a URL. It changes nothing on the
+// server and the same upload always gets the same answer, so repeating it is as safe as repeating a GET.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per call including the first, 1 or less never retries
+	BaseDelay   time.Duration // Delay before the first retry, doubling for each one after
+	MaxDelay    time.Duration // Longest delay between attempts, a longer Retry-After gives up instead
+}
+
+// DefaultRetryPolicy suits interactive use, giving up within a few seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// retryDelay returns how long to wait before retrying a failed attempt, or false when it should not be retried.
+// Delays use "full jitter", a random duration up to the exponential backoff, so parallel lookups
+// that failed together do not retry together.
+// see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func (p *RetryPolicy) retryDelay(ctx context.Context, attempt int, statusCode int, retryAfter time.Duration, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !isTransient(statusCode, err) {
+		return 0, false
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= p.MaxDelay
+	}
+
+	backoff := min(p.MaxDelay, p.BaseDelay<<min(attempt-1, 30)) // capping the shift keeps it from overflowing
+	if backoff <= 0 {
+		return 0, true
+	}
+	return rand.N(backoff + 1), true
+}
+
+// isTransient reports whether an attempt failed in a way a later attempt may not.
+// Timeouts count, so callers must check their own context has not ended first.
+func isTransient(statusCode int, err error) bool {
+	if err == nil {
+		switch statusCode {
+		case http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as an HTTP date.
+// see https://www.rfc-editor.org/rfc/rfc9110#field.retry-after
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(0, seconds)) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(0, time.Until(date))
+	}
+	return 0
+}
+
+// sleep waits for d, or returns the error of ctx if it is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
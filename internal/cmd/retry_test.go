@@ -0,0 +1,152 @@
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// scriptedServer answers each request with the next response in the script, repeating the last one.
+type scriptedResponse struct {
+	statusCode int
+	retryAfter string
+	hangUp     bool // close the connection without answering
+}
+
+func newScriptedServer(t *testing.T, script ...scriptedResponse) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := script[min(calls, len(script)-1)]
+		calls++
+
+		if response.hangUp {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatalf("failed to hijack the connection: %v", err)
+			}
+			_ = conn.Close()
+			return
+		}
+		if response.retryAfter != "" {
+			w.Header().Set("Retry-After", response.retryAfter)
+		}
+		w.WriteHeader(response.statusCode)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestDirectGeocoding_Retry(t *testing.T) {
+	policy := &internalcmd.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	}
+
+	tests := map[string]struct {
+		script      []scriptedResponse
+		retry       *internalcmd.RetryPolicy
+		expectedErr error
+		expectCalls int
+	}{
+		"success is not retried": {
+			script:      []scriptedResponse{{statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: nil,
+			expectCalls: 1,
+		},
+		"server errors are retried until success": {
+			script:      []scriptedResponse{{statusCode: http.StatusServiceUnavailable}, {statusCode: http.StatusBadGateway}, {statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: nil,
+			expectCalls: 3,
+		},
+		"server errors give up after the last attempt": {
+			script:      []scriptedResponse{{statusCode: http.StatusInternalServerError}},
+			retry:       policy,
+			expectedErr: internalcmd.ErrUpstream,
+			expectCalls: 3,
+		},
+		"rate limiting honors Retry-After": {
+			script:      []scriptedResponse{{statusCode: http.StatusTooManyRequests, retryAfter: "0"}, {statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: nil,
+			expectCalls: 2,
+		},
+		"Retry-After beyond the max delay gives up": {
+			script:      []scriptedResponse{{statusCode: http.StatusTooManyRequests, retryAfter: "120"}, {statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: internalcmd.ErrRateLimited,
+			expectCalls: 1,
+		},
+		"dropped connections are retried": {
+			script:      []scriptedResponse{{hangUp: true}, {statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: nil,
+			expectCalls: 2,
+		},
+		"client errors are not retried": {
+			script:      []scriptedResponse{{statusCode: http.StatusUnauthorized}, {statusCode: http.StatusOK}},
+			retry:       policy,
+			expectedErr: internalcmd.ErrUnauthorized,
+			expectCalls: 1,
+		},
+		"no policy never retries": {
+			script:      []scriptedResponse{{statusCode: http.StatusServiceUnavailable}, {statusCode: http.StatusOK}},
+			expectedErr: internalcmd.ErrUpstream,
+			expectCalls: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, calls := newScriptedServer(t, tc.script...)
+			g := internalcmd.DirectGeocoding{
+				Key:     "test-key",
+				BaseURL: server.URL,
+				Retry:   tc.retry,
+			}
+
+			_, err := g.LocationByName("Henrico, VA")
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("LocationByName() Unexpected error: %v, expected %v", err, tc.expectedErr)
+			}
+			if *calls != tc.expectCalls {
+				t.Errorf("LocationByName() made %d calls, expected %d", *calls, tc.expectCalls)
+			}
+		})
+	}
+}
+
+func TestDirectGeocoding_RetryCancelled(t *testing.T) {
+	server, calls := newScriptedServer(t, scriptedResponse{statusCode: http.StatusServiceUnavailable})
+	g := internalcmd.DirectGeocoding{
+		Key:     "test-key",
+		BaseURL: server.URL,
+		Retry: &internalcmd.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Second,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := g.LocationByNameContext(ctx, "Henrico, VA")
+	if err == nil {
+		t.Fatalf("LocationByNameContext() Expected error, but didn't get one")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("LocationByNameContext() kept retrying for %s after its deadline", elapsed)
+	}
+	if *calls > 2 {
+		t.Errorf("LocationByNameContext() made %d calls, expected the deadline to stop retries", *calls)
+	}
+}
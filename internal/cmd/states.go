@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// usStates are the US states, the District of Columbia and the inhabited territories, by USPS code.
+var usStates = map[string]string{
+	"AK": "Alaska", "AL": "Alabama", "AR": "Arkansas", "AS": "American Samoa", "AZ": "Arizona",
+	"CA": "California", "CO": "Colorado", "CT": "Connecticut", "DC": "District of Columbia", "DE": "Delaware",
+	"FL": "Florida", "GA": "Georgia", "GU": "Guam", "HI": "Hawaii", "IA": "Iowa",
+	"ID": "Idaho", "IL": "Illinois", "IN": "Indiana", "KS": "Kansas", "KY": "Kentucky",
+	"LA": "Louisiana", "MA": "Massachusetts", "MD": "Maryland", "ME": "Maine", "MI": "Michigan",
+	"MN": "Minnesota", "MO": "Missouri", "MP": "Northern Mariana Islands", "MS": "Mississippi", "MT": "Montana",
+	"NC": "North Carolina", "ND": "North Dakota", "NE": "Nebraska", "NH": "New Hampshire", "NJ": "New Jersey",
+	"NM": "New Mexico", "NV": "Nevada", "NY": "New York", "OH": "Ohio", "OK": "Oklahoma",
+	"OR": "Oregon", "PA": "Pennsylvania", "PR": "Puerto Rico", "RI": "Rhode Island", "SC": "South Carolina",
+	"SD": "South Dakota", "TN": "Tennessee", "TX": "Texas", "UT": "Utah", "VA": "Virginia",
+	"VI": "U.S. Virgin Islands", "VT": "Vermont", "WA": "Washington", "WI": "Wisconsin", "WV": "West Virginia",
+	"WY": "Wyoming",
+}
+
+var usStatesByName = map[string]string{}
+
+func init() {
+	for code, name := range usStates {
+		usStatesByName[countryKey(code)] = code
+		usStatesByName[countryKey(name)] = code
+	}
+}
+
+// USStateByName returns the USPS code of a US state, district or territory, given its code or name in any case,
+// so "Virginia", "va" and "Va." are all "VA".
+func USStateByName(name string) (string, bool) {
+	code, ok := usStatesByName[countryKey(name)]
+	return code, ok
+}
+
+func isUSState(s string) bool {
+	_, ok := USStateByName(s)
+	return ok
+}
+
+// withUSStateCode replaces the state of a US place name, split on commas, with its code.
+func withUSStateCode(parts []string) []string {
+	if len(parts) == 2 {
+		if code, ok := USStateByName(parts[1]); ok {
+			return []string{parts[0], code}
+		}
+	}
+	return parts
+}
+
+// splitUSState splits the state off the end of a US place name without commas, such as "richmond va" or
+// "charleston west virginia", returning the city and the state code. Places such as "Fort Washington" or
+// "Port Washington" also end in a state name, so a name is only read this way once it has matched nothing as given.
+func splitUSState(parts []string) ([]string, bool) {
+	if len(parts) != 1 || isUSState(parts[0]) {
+		return parts, false
+	}
+
+	words := strings.Fields(parts[0])
+	for n := min(3, len(words)-1); n > 0; n-- { // state names have up to three words
+		if code, ok := USStateByName(strings.Join(words[len(words)-n:], " ")); ok {
+			return []string{strings.Join(words[:len(words)-n], " "), code}, true
+		}
+	}
+	return parts, false
+}
+
+// usStateCode returns the code of a state as OpenWeather names it in results, which is only known for US places.
+func usStateCode(country, state string) string {
+	if country != "US" {
+		return ""
+	}
+	code, _ := USStateByName(state)
+	return code
+}
+
+// FilterState returns a copy of the lookup keeping only the records in the US state with the given code.
+// Zip lookups are returned as they are, as OpenWeather does not report the state of a postal code.
+func (l Lookup) FilterState(code string) Lookup {
+	if l.Type == LookupZip || l.Records == nil {
+		return l
+	}
+	var records []Record
+	for _, r := range l.Records {
+		if r.StateCode == code {
+			records = append(records, r)
+		}
+	}
+	l.Records = records
+	return l
+}
@@ -0,0 +1,79 @@
+package cmd_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	internalcmd "github.com/squeedee/geo/internal/cmd"
+	"testing"
+)
+
+func TestUSStateByName(t *testing.T) {
+	tests := map[string]struct {
+		name         string
+		expectedCode string
+	}{
+		"code":                        {name: "VA", expectedCode: "VA"},
+		"code in any case":            {name: "va", expectedCode: "VA"},
+		"abbreviation":                {name: "Va.", expectedCode: "VA"},
+		"name":                        {name: "Virginia", expectedCode: "VA"},
+		"name of several words":       {name: "district of  columbia", expectedCode: "DC"},
+		"territory":                   {name: "Puerto Rico", expectedCode: "PR"},
+		"not a state":                 {name: "Henrico"},
+		"province of another country": {name: "Ontario"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, ok := internalcmd.USStateByName(tc.name)
+			if ok != (tc.expectedCode != "") {
+				t.Fatalf("USStateByName() found a state: %t, expected %t", ok, !ok)
+			}
+			if code != tc.expectedCode {
+				t.Errorf("USStateByName() = '%s', expected '%s'", code, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestLookup_FilterState(t *testing.T) {
+	richmonds := internalcmd.NewNameLookup("Richmond", internalcmd.LookupName, []internalcmd.NameResult{
+		{Name: "Richmond", Lat: 37.5385, Lon: -77.4343, Country: "US", State: "Virginia"},
+		{Name: "Richmond", Lat: 51.4613, Lon: -0.3037, Country: "GB", State: "England"},
+		{Name: "Richmond", Lat: 37.9358, Lon: -122.3477, Country: "US", State: "California"},
+	})
+
+	tests := map[string]struct {
+		lookup   internalcmd.Lookup
+		code     string
+		expected internalcmd.Lookup
+	}{
+		"keeps the records in the state": {
+			lookup: richmonds,
+			code:   "VA",
+			expected: internalcmd.NewNameLookup("Richmond", internalcmd.LookupName, []internalcmd.NameResult{
+				{Name: "Richmond", Lat: 37.5385, Lon: -77.4343, Country: "US", State: "Virginia"},
+			}),
+		},
+		"no records in the state": {
+			lookup:   richmonds,
+			code:     "TX",
+			expected: internalcmd.NewNameLookup("Richmond", internalcmd.LookupName, nil),
+		},
+		"zip lookups are not filtered": {
+			lookup:   zipLookup,
+			code:     "TX",
+			expected: zipLookup,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.expected, tc.lookup.FilterState(tc.code)); diff != "" {
+				t.Errorf("FilterState() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if len(richmonds.Records) != 3 {
+		t.Errorf("FilterState() changed the original lookup, which now has %d records", len(richmonds.Records))
+	}
+}
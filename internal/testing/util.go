@@ -2,6 +2,7 @@ package testing
 
 import (
 	"context"
+	"errors"
 	"os/exec"
 	"strings"
 	"testing"
@@ -58,3 +59,17 @@ func StrPtr(s string) *string {
 	return &s
 
 }
+
+// ExitCode returns the exit code of a command from the error it finished with, 0 when there was none.
+func ExitCode(t TestingT, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("command did not run to completion: %s", err)
+		return -1
+	}
+	return exitErr.ExitCode()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	internaltesting "github.com/squeedee/geo/internal/testing"
+	"os/exec"
 	"testing"
 )
 
@@ -86,3 +87,37 @@ func TestStringMatcher_MatchText(t *testing.T) {
 		})
 	}
 }
+
+func TestExitCode(t *testing.T) {
+	tests := map[string]struct {
+		cmd          *exec.Cmd
+		expectedCode int
+		shouldFail   bool
+	}{
+		"success is 0": {
+			cmd: exec.Command("sh", "-c", "exit 0"),
+		},
+		"failure is the exit status": {
+			cmd:          exec.Command("sh", "-c", "exit 4"),
+			expectedCode: 4,
+		},
+		"a command that cannot start fails the test": {
+			cmd:          exec.Command("./does-not-exist"),
+			expectedCode: -1,
+			shouldFail:   true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := &TestMock{}
+			code := internaltesting.ExitCode(mock, tc.cmd.Run())
+
+			if code != tc.expectedCode {
+				t.Fatalf("ExitCode() returned %d, expected %d", code, tc.expectedCode)
+			}
+			if failed := mock.FatalfCallCount > 0; failed != tc.shouldFail {
+				t.Fatalf("ExitCode() failed the test: %t, expected %t", failed, tc.shouldFail)
+			}
+		})
+	}
+}
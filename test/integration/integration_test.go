@@ -8,8 +8,11 @@ import (
 	. "github.com/MakeNowJust/heredoc/dot"
 	"github.com/squeedee/geo/cmd"
 	internaltesting "github.com/squeedee/geo/internal/testing"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 	"time"
 )
@@ -20,12 +23,49 @@ var ApiKey = os.Getenv(cmd.ApiKeyName)
 // but I've included the full usage message for completeness.
 var usageMessage = D(`Usage:
   geo [flags]
+  geo [command]
 
 Examples:
   geo "Henrico, VA" 10001 "Seattle, WA"
 
+Available Commands:
+  batch       Geo-locate every query in a CSV file, writing the rows back with their coordinates
+  cache       Inspect and manage the lookup cache
+  completion  Generate the autocompletion script for the specified shell
+  db          Build the offline GeoNames index of '--provider offline'
+  help        Help about any command
+  reverse     Find the place names nearest to a set of coordinates
+
 Flags:
-  -h, --help   help for geo`)
+      --api-url string                OpenWeather API base URL, also set with 'OPEN_WEATHER_API_URL' (default "https://api.openweathermap.org")
+      --cache-negative-ttl duration   how long a lookup that matched nothing is reused from the cache (default 24h0m0s)
+      --cache-ttl duration            how long a lookup that matched is reused from the cache (default 720h0m0s)
+      --census-benchmark string       address ranges '--provider census' searches, such as 'Public_AR_Census2020' (default "Public_AR_Current")
+      --census-url string             Census geocoder of '--provider census', also set with 'CENSUS_GEOCODER_URL' (default "https://geocoding.geo.census.gov/geocoder")
+      --census-vintage string         census geography '--provider census' reports tracts and blocks of, such as 'Census2020_Census2020' (default "Current_Current")
+      --country string                ISO 3166 alpha-2 or alpha-3 country of place names and postal codes that do not name one, or 'none', also set with 'GEO_DEFAULT_COUNTRY' (default "US")
+      --db string                     GeoNames index of '--provider offline' and 'geo db', also set with 'GEO_DB'
+      --first                         print only the best match of each query, for scripts that need exactly one coordinate
+  -h, --help                          help for geo
+      --keep-going                    look up every query even after one fails or matches nothing, then list the status of each
+      --lang string                   ISO 639-1 language of place names, such as 'es', falling back to the provider's name when it has no translation
+      --limit int                     maximum number of matches per place name (1-5), left to the provider when unset
+      --no-cache                      neither read nor write the lookup cache
+      --nominatim-email string        contact address sent to Nominatim, for the server operators to reach you about heavy use
+      --nominatim-url string          Nominatim server of '--provider nominatim', also set with 'NOMINATIM_URL' (default "https://nominatim.openstreetmap.org")
+      --nominatim-user-agent string   User-Agent sent to Nominatim, naming your application as the public server's usage policy asks (default "geo (https://github.com/squeedee/geo)")
+  -o, --output string                 output format, one of: text, json, ndjson, csv, yaml, geojson, kml, gpx (default "text")
+      --parallel int                  number of lookups to run at once, results keep their input order (default 1)
+      --provider string               geocoding provider, one of: census, nominatim, offline, openweather (default "openweather")
+      --rate-burst int                API calls allowed back to back before '--rate-limit' spaces them out (default 10)
+      --rate-limit int                maximum API calls per minute, 0 for no limit (default 60)
+      --refresh                       ignore cached lookups, replacing them with fresh ones from the provider
+      --relax                         retry a place name that matches nothing without its street, county, then neighbourhood, reporting the form that matched
+      --retry-attempts int            attempts per API call when the provider is rate limiting, failing or unreachable, 1 never retries (default 3)
+      --retry-base-delay duration     delay before the first retry, doubling for each retry after (default 500ms)
+      --retry-max-delay duration      longest delay between retries, including any 'Retry-After' from the provider (default 30s)
+      --state string                  only keep US places in this state, by code or name such as 'VA', postal codes are not filtered
+      --timeout duration              time limit for each API call, 0 for no limit (default 10s)`)
 
 func TestIntegrationWithWorkingKey(t *testing.T) {
 	internaltesting.MustCompileOnce(t)
@@ -35,16 +75,16 @@ func TestIntegrationWithWorkingKey(t *testing.T) {
 	}
 
 	tests := map[string]struct {
-		Args         []string
-		ExpectOutput []string
-		ExpectError  bool
+		Args           []string
+		ExpectOutput   []string
+		ExpectExitCode int
 	}{
-		"'geo', no arguments provided -> exit code 1, help user and display usage": {
+		"'geo', no arguments provided -> exit code 2, help user and display usage": {
 			ExpectOutput: []string{
 				"No location arguments provided, please provide at least one location name, ZIP or Postal Code.",
 				usageMessage,
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitUsage,
 		},
 		"'geo -h', help flag -> displays usage": {
 			Args: []string{
@@ -62,13 +102,13 @@ func TestIntegrationWithWorkingKey(t *testing.T) {
 				"  Lat,Lon: 37.463800, -77.398000",
 			},
 		},
-		"'geo 99999', invalid zip code -> exit code 1, display not found message": {
+		"'geo 99999', invalid zip code -> exit code 4, display not found message": {
 			Args: []string{"99999"},
 			ExpectOutput: []string{
 				"'99999' results:",
 				"  No matches found.",
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitNotFound,
 		},
 		"'geo \"Henrico, VA\"', valid place name -> loc(Henrico, VA)": {
 			Args: []string{"Henrico, VA"},
@@ -86,21 +126,57 @@ func TestIntegrationWithWorkingKey(t *testing.T) {
 				"Lat,Lon: 37.336166, -121.890591",
 			},
 		},
-		"'geo \"not-a-place, NY\"', invalid place -> exit code 1, display not found message": {
+		"'geo \"not-a-place, NY\"', invalid place -> exit code 4, display not found message": {
 			Args: []string{"not-a-place, NY"},
 			ExpectOutput: []string{
 				"'not-a-place, NY' results:",
 				"  No matches found.",
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitNotFound,
 		},
-		"'geo \"壚靁縅-lalala\"', invalid place and special characters -> exit code 1, display not found message": {
+		"'geo \"壚靁縅-lalala\"', invalid place and special characters -> exit code 4, display not found message": {
 			Args: []string{"壚靁縅-lalala"},
 			ExpectOutput: []string{
 				"'壚靁縅-lalala' results:",
 				"  No matches found.",
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"'geo reverse 37.5385087,-77.43428', valid coordinates -> Richmond, VA": {
+			Args: []string{"reverse", "37.5385087,-77.43428"},
+			ExpectOutput: []string{
+				"'37.5385087,-77.43428' results:",
+				"  Name: Richmond, Virginia, US",
+			},
+		},
+		"'geo reverse 91,0', out of range latitude -> exit code 2, display validation message": {
+			Args: []string{"reverse", "91,0"},
+			ExpectOutput: []string{
+				"'91' is not a valid latitude, expected a number between -90 and 90",
+			},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"'geo -o ndjson 23228', structured output -> one JSON record per line": {
+			Args: []string{"-o", "ndjson", "23228"},
+			ExpectOutput: []string{
+				`{"query":"23228","type":"zip","name":"Henrico County","state":"","state_code":"","country":"US","zip":"23228","lat":37.4638,"lon":-77.398}` + "\n",
+			},
+		},
+		"'geo -o xml 23228', unknown output format -> exit code 2, list the formats": {
+			Args: []string{"-o", "xml", "23228"},
+			ExpectOutput: []string{
+				"unknown output format 'xml', expected one of: text, json, ndjson, csv, yaml, geojson, kml, gpx",
+			},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"'geo batch --input testdata/places.csv --column address', CSV input -> enriched CSV": {
+			Args: []string{"batch", "--input", "testdata/places.csv", "--column", "address"},
+			ExpectOutput: []string{
+				"id,address,geo_lat,geo_lon,geo_name,geo_state,geo_country,geo_status\n",
+				"1,\"Henrico, VA\",37.495702,-77.335257,Henrico,Virginia,US,ok\n",
+				"2,23228,37.4638,-77.398,Henrico County,,US,ok\n",
+				"3,,,,,,,empty\n",
+			},
 		},
 		"Test text layout": {
 			Args: []string{"Henrico, VA", "10001", "Seattle, WA"},
@@ -119,6 +195,23 @@ func TestIntegrationWithWorkingKey(t *testing.T) {
 				"\n",
 			},
 		},
+		"Test text layout with parallel lookups keeps the input order": {
+			Args: []string{"--parallel", "3", "Henrico, VA", "10001", "Seattle, WA"},
+			ExpectOutput: []string{
+				"'Henrico, VA' results:\n",
+				"  Name: Henrico, Virginia, US\n",
+				"  Lat,Lon: 37.495702, -77.335257\n",
+				"\n",
+				"'10001' results:\n",
+				"  Name: New York, US, 10001\n",
+				"  Lat,Lon: 40.748400, -73.996700\n",
+				"\n",
+				"'Seattle, WA' results:\n",
+				"  Name: Seattle, Washington, US\n",
+				"  Lat,Lon: 47.603832, -122.330062\n",
+				"\n",
+			},
+		},
 	}
 
 	for name, tc := range tests {
@@ -129,14 +222,12 @@ func TestIntegrationWithWorkingKey(t *testing.T) {
 			geoCmd := exec.CommandContext(ctx, "../../build/geo", tc.Args...)
 			geoCmd.Env = []string{
 				fmt.Sprintf("%s=%s", cmd.ApiKeyName, ApiKey),
+				fmt.Sprintf("XDG_CACHE_HOME=%s", t.TempDir()), // every case starts with an empty cache
 			}
 
 			outStr, err := geoCmd.CombinedOutput()
-			if err != nil && !tc.ExpectError {
-				t.Logf("unexpected error: %s", err)
-				t.Fail()
-			} else if err == nil && tc.ExpectError {
-				t.Logf("expected error did not occur")
+			if code := internaltesting.ExitCode(t, err); code != tc.ExpectExitCode {
+				t.Logf("unexpected exit code %d, expected %d", code, tc.ExpectExitCode)
 				t.Fail()
 			}
 
@@ -157,10 +248,10 @@ func TestIntegrationApiKey(t *testing.T) {
 	}
 
 	tests := map[string]struct {
-		ApiKey       *string
-		Args         []string
-		ExpectOutput []string
-		ExpectError  bool
+		ApiKey         *string
+		Args           []string
+		ExpectOutput   []string
+		ExpectExitCode int
 	}{
 		"API Key not provided -> provide user guidance": {
 			ApiKey: nil,
@@ -169,7 +260,7 @@ func TestIntegrationApiKey(t *testing.T) {
 				fmt.Sprintf("'%s' not set. Please visit 'https://openweathermap.org/api' and obtain an API key.", cmd.ApiKeyName),
 				fmt.Sprintf("Set the key before runing 'geo' with:\n\texport %s=<your openweather api key>", cmd.ApiKeyName),
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitAuth,
 		},
 		"API Key Empty -> provide user guidance": {
 			ApiKey: internaltesting.StrPtr(""),
@@ -178,7 +269,7 @@ func TestIntegrationApiKey(t *testing.T) {
 				fmt.Sprintf("'%s' not set. Please visit 'https://openweathermap.org/api' and obtain an API key.", cmd.ApiKeyName),
 				fmt.Sprintf("Set the key before runing 'geo' with:\n\texport %s=<your openweather api key>", cmd.ApiKeyName),
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitAuth,
 		},
 		"API Key Invalid, zip code -> provide user guidance": { // Testing zip code path NOTE1
 			ApiKey: internaltesting.StrPtr("invalid-key"),
@@ -186,7 +277,7 @@ func TestIntegrationApiKey(t *testing.T) {
 			ExpectOutput: []string{
 				fmt.Sprintf("'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.", cmd.ApiKeyName),
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitAuth,
 		},
 		"API Key Invalid, location name -> provide user guidance": { // Testing place code path NOTE1
 			ApiKey: internaltesting.StrPtr("invalid-key"),
@@ -194,7 +285,7 @@ func TestIntegrationApiKey(t *testing.T) {
 			ExpectOutput: []string{
 				fmt.Sprintf("'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.", cmd.ApiKeyName),
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitAuth,
 		},
 		"API Key Invalid, multiple arguments -> provide user guidance": {
 			ApiKey: internaltesting.StrPtr("invalid-key"),
@@ -202,12 +293,11 @@ func TestIntegrationApiKey(t *testing.T) {
 			ExpectOutput: []string{
 				fmt.Sprintf("'%s' is invalid. Please ensure you have the correct key from 'https://openweathermap.org/api'.", cmd.ApiKeyName),
 			},
-			ExpectError: true,
+			ExpectExitCode: cmd.ExitAuth,
 		},
 		"API Key valid -> no error": {
-			ApiKey:      internaltesting.StrPtr(ApiKey),
-			Args:        []string{"23228"},
-			ExpectError: false,
+			ApiKey: internaltesting.StrPtr(ApiKey),
+			Args:   []string{"23228"},
 		},
 	}
 
@@ -229,15 +319,348 @@ func TestIntegrationApiKey(t *testing.T) {
 			if tc.ApiKey != nil {
 				geoCmd.Env = []string{
 					fmt.Sprintf("%s=%s", cmd.ApiKeyName, *tc.ApiKey),
+					fmt.Sprintf("XDG_CACHE_HOME=%s", t.TempDir()), // a cached lookup would hide an invalid key
 				}
 			}
 
 			outStr, err := geoCmd.CombinedOutput()
-			if err != nil && !tc.ExpectError {
-				t.Logf("unexpected error: %s", err)
+			if code := internaltesting.ExitCode(t, err); code != tc.ExpectExitCode {
+				t.Logf("unexpected exit code %d, expected %d", code, tc.ExpectExitCode)
 				t.Fail()
-			} else if err == nil && tc.ExpectError {
-				t.Logf("expected error did not occur")
+			}
+
+			outputMatcher := internaltesting.NewOutputMatcher(string(outStr))
+
+			for _, expectedOutput := range tc.ExpectOutput {
+				outputMatcher.MatchText(t, expectedOutput)
+			}
+		})
+	}
+}
+
+func TestIntegrationCache(t *testing.T) {
+	internaltesting.MustCompileOnce(t)
+
+	if ApiKey == "" {
+		t.Fatalf("No %s set", cmd.ApiKeyName)
+	}
+
+	cacheHome := t.TempDir()
+
+	// Runs share one cache, each with the key given. An invalid key only fails when OpenWeather is called.
+	steps := []struct {
+		Name           string
+		ApiKey         string
+		Args           []string
+		ExpectOutput   []string
+		ExpectExitCode int
+	}{
+		{
+			Name:         "first lookup is stored",
+			ApiKey:       ApiKey,
+			Args:         []string{"23228"},
+			ExpectOutput: []string{"  Name: Henrico County, US, 23228"},
+		},
+		{
+			Name:         "repeat lookup is served from the cache",
+			ApiKey:       "invalid-key",
+			Args:         []string{"23228"},
+			ExpectOutput: []string{"  Name: Henrico County, US, 23228"},
+		},
+		{
+			Name:           "another --api-url is not served from the cache",
+			ApiKey:         ApiKey,
+			Args:           []string{"--retry-attempts", "1", "--api-url", "http://127.0.0.1:1", "23228"},
+			ExpectOutput:   []string{"unexpected error when getting the location '23228'"},
+			ExpectExitCode: cmd.ExitUpstream,
+		},
+		{
+			Name:           "--refresh calls OpenWeather",
+			ApiKey:         "invalid-key",
+			Args:           []string{"--refresh", "23228"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		{
+			Name:           "--no-cache calls OpenWeather",
+			ApiKey:         "invalid-key",
+			Args:           []string{"--no-cache", "23228"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		{
+			Name:         "cache stats counts the stored lookup",
+			Args:         []string{"cache", "stats"},
+			ExpectOutput: []string{"Entries: 1 (1 matched, 0 matched nothing, 0 expired)"},
+		},
+		{
+			Name:         "cache purge removes the lookup",
+			Args:         []string{"cache", "purge", "--query", "23228"},
+			ExpectOutput: []string{"Purged 1 entries."},
+		},
+		{
+			Name:           "purged lookup calls OpenWeather",
+			ApiKey:         "invalid-key",
+			Args:           []string{"23228"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+	}
+
+	for _, step := range steps {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+
+		geoCmd := exec.CommandContext(ctx, "../../build/geo", step.Args...)
+		geoCmd.Env = []string{
+			fmt.Sprintf("%s=%s", cmd.ApiKeyName, step.ApiKey),
+			fmt.Sprintf("XDG_CACHE_HOME=%s", cacheHome),
+		}
+
+		outStr, err := geoCmd.CombinedOutput()
+		cancel()
+		if code := internaltesting.ExitCode(t, err); code != step.ExpectExitCode {
+			t.Fatalf("%s: unexpected exit code %d, expected %d\n%s", step.Name, code, step.ExpectExitCode, outStr)
+		}
+
+		outputMatcher := internaltesting.NewOutputMatcher(string(outStr))
+		for _, expectedOutput := range step.ExpectOutput {
+			outputMatcher.MatchText(t, expectedOutput)
+		}
+	}
+}
+
+// TestIntegrationExitCodes runs geo against a stub of OpenWeather, as the real API cannot be made to fail on demand.
+func TestIntegrationExitCodes(t *testing.T) {
+	internaltesting.MustCompileOnce(t)
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("appid") != "stub-key":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"cod":401,"message":"Invalid API key."}`))
+		case strings.HasPrefix(q.Get("q"), "fail"):
+			w.WriteHeader(http.StatusBadGateway)
+		case strings.HasPrefix(q.Get("q"), "Short Pump"):
+			_, _ = w.Write([]byte(`[]`))
+		case strings.HasPrefix(q.Get("zip"), "99999"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"cod":"404","message":"not found"}`))
+		case q.Has("zip"):
+			_, _ = w.Write([]byte(`{"zip":"23228","name":"Henrico County","lat":37.4638,"lon":-77.398,"country":"US"}`))
+		default:
+			_, _ = w.Write([]byte(`[{"name":"Henrico","lat":37.495702,"lon":-77.335257,"country":"US","state":"Virginia"}]`))
+		}
+	}))
+	defer stub.Close()
+
+	tests := map[string]struct {
+		ApiKey         *string
+		ApiUrl         string
+		Args           []string
+		Stdin          string
+		ExpectOutput   []string
+		ExpectExitCode int
+	}{
+		"matches -> exit code 0": {
+			Args: []string{"Henrico, VA", "23228"},
+		},
+		"invalid flag value -> exit code 2": {
+			Args:           []string{"--parallel", "0", "23228"},
+			ExpectOutput:   []string{"'--parallel' must be at least 1, got 0."},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"unknown country -> exit code 2": {
+			Args:           []string{"--country", "XYZ", "Paris"},
+			ExpectOutput:   []string{"'--country' must be an ISO 3166 alpha-2 or alpha-3 code"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"unknown state -> exit code 2": {
+			Args:           []string{"--state", "XX", "Richmond"},
+			ExpectOutput:   []string{"'--state' must be a US state code or name"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"limit out of range -> exit code 2": {
+			Args:           []string{"--limit", "6", "Richmond"},
+			ExpectOutput:   []string{"'--limit' must be between 1 and 5, got 6."},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"unknown provider -> exit code 2": {
+			Args:           []string{"--provider", "nowhere", "Richmond"},
+			ExpectOutput:   []string{"unknown provider 'nowhere', expected one of: census, nominatim, offline, openweather"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"offline provider without an index -> exit code 1": {
+			Args:           []string{"--provider", "offline", "--db", "nowhere/geonames.db", "Richmond"},
+			ExpectOutput:   []string{"no offline index at 'nowhere/geonames.db', build one with 'geo db import <file>'."},
+			ExpectExitCode: cmd.ExitError,
+		},
+		"API URL that is not http -> exit code 2": {
+			Args:           []string{"--api-url", "ftp://x", "23228"},
+			ExpectOutput:   []string{"'--api-url' must be an http or https URL such as 'https://example.com', got 'ftp://x'."},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"Nominatim URL without a host -> exit code 2": {
+			Args:           []string{"--provider", "nominatim", "--nominatim-url", "nominatim.internal", "Richmond"},
+			ExpectOutput:   []string{"'--nominatim-url' must be an http or https URL such as 'https://example.com', got 'nominatim.internal'."},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"reverse with a latitude that is not a number -> exit code 2": {
+			Args:           []string{"reverse", "NaN,0"},
+			ExpectOutput:   []string{"'NaN' is not a valid latitude, expected a number between -90 and 90"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"reverse with a longitude that is not a number -> exit code 2": {
+			Args:           []string{"reverse", "0,NaN"},
+			ExpectOutput:   []string{"'NaN' is not a valid longitude, expected a number between -180 and 180"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"unknown flag -> exit code 2": {
+			Args:           []string{"--no-such-flag", "23228"},
+			ExpectOutput:   []string{"unknown flag: --no-such-flag"},
+			ExpectExitCode: cmd.ExitUsage,
+		},
+		"missing API key -> exit code 3": {
+			ApiKey:         internaltesting.StrPtr(""),
+			Args:           []string{"23228"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' not set.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		"invalid API key -> exit code 3": {
+			ApiKey:         internaltesting.StrPtr("invalid-key"),
+			Args:           []string{"23228"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		"another provider refusing the request -> exit code 3, no API key hint": {
+			Args:           []string{"--provider", "nominatim", "--nominatim-url", stub.URL, "Richmond"},
+			ExpectOutput:   []string{"the 'nominatim' provider refused the request (401/403). Please check the server or proxy in front of it."},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		"no match -> exit code 4": {
+			Args:           []string{"99999"},
+			ExpectOutput:   []string{"'99999' results:", "  No matches found."},
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"postal code with the census provider -> no match, exit code 4": {
+			Args:           []string{"--provider", "census", "23228"},
+			ExpectOutput:   []string{"'23228' results:", "  No matches found."},
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"no match without --relax -> exit code 4": {
+			Args:           []string{"Short Pump, Henrico County, VA"},
+			ExpectOutput:   []string{"  No matches found."},
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"--relax with a relaxed match -> exit code 0": {
+			Args:         []string{"--relax", "Short Pump, Henrico County, VA"},
+			ExpectOutput: []string{"'Short Pump, Henrico County, VA' results:", "  No exact match, relaxed to 'Henrico, VA' (city precision)."},
+		},
+		"batch --relax -> relaxed columns": {
+			Args:         []string{"batch", "--relax"},
+			Stdin:        "query\nShort Pump, Henrico County, VA\n",
+			ExpectOutput: []string{"query,geo_lat,geo_lon,geo_name,geo_state,geo_country,geo_status,geo_relaxed,geo_precision\n", `"Henrico, VA",city`},
+		},
+		"OpenWeather failing -> exit code 5": {
+			Args:           []string{"fail"},
+			ExpectOutput:   []string{"unexpected error when getting the location 'fail': unexpected response (status code 502)"},
+			ExpectExitCode: cmd.ExitUpstream,
+		},
+		"OpenWeather unreachable -> exit code 5": {
+			ApiUrl:         "http://127.0.0.1:1",
+			Args:           []string{"23228"},
+			ExpectOutput:   []string{"unexpected error when getting the location '23228'"},
+			ExpectExitCode: cmd.ExitUpstream,
+		},
+		"--keep-going with some failed queries -> every query is looked up, exit code 6": {
+			Args: []string{"--keep-going", "Henrico, VA", "fail", "99999", "23228"},
+			ExpectOutput: []string{
+				"'Henrico, VA' results:",
+				"unexpected error when getting the location 'fail'",
+				"'99999' results:",
+				"'23228' results:",
+				"ok        Henrico, VA\n",
+				"error     fail\n",
+				"no_match  99999\n",
+				"ok        23228\n",
+				"Processed 4 queries: 2 ok, 1 no_match, 1 error.",
+			},
+			ExpectExitCode: cmd.ExitPartial,
+		},
+		"--keep-going with no matches -> exit code of the first query, 4": {
+			Args:           []string{"--keep-going", "99999", "fail"},
+			ExpectOutput:   []string{"Processed 2 queries: 1 no_match, 1 error."},
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"--keep-going with an invalid API key -> stops at once, exit code 3": {
+			ApiKey:         internaltesting.StrPtr("invalid-key"),
+			Args:           []string{"--keep-going", "23228", "Henrico, VA"},
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		"batch with some failed queries -> exit code 6": {
+			Args:           []string{"batch"},
+			Stdin:          "query\nHenrico, VA\nfail\n",
+			ExpectOutput:   []string{"Processed 2 queries: 1 ok, 1 error."},
+			ExpectExitCode: cmd.ExitPartial,
+		},
+		"batch with every query failed -> exit code 5": {
+			Args:           []string{"batch"},
+			Stdin:          "query\nfail-1\nfail-2\n",
+			ExpectOutput:   []string{"Processed 2 queries: 2 error."},
+			ExpectExitCode: cmd.ExitUpstream,
+		},
+		"batch with every query matching nothing -> exit code 4": {
+			Args:           []string{"batch"},
+			Stdin:          "query\nShort Pump, VA\n99999\n",
+			ExpectOutput:   []string{"Processed 2 queries: 2 no_match."},
+			ExpectExitCode: cmd.ExitNotFound,
+		},
+		"batch with some queries matching nothing -> exit code 6": {
+			Args:           []string{"batch"},
+			Stdin:          "query\nHenrico, VA\n99999\n",
+			ExpectOutput:   []string{"Processed 2 queries: 1 ok, 1 no_match."},
+			ExpectExitCode: cmd.ExitPartial,
+		},
+		"batch with an invalid API key -> exit code 3": {
+			ApiKey:         internaltesting.StrPtr("invalid-key"),
+			Args:           []string{"batch"},
+			Stdin:          "query\nHenrico, VA\n23228\n",
+			ExpectOutput:   []string{fmt.Sprintf("'%s' is invalid.", cmd.ApiKeyName)},
+			ExpectExitCode: cmd.ExitAuth,
+		},
+		"cache warm with every query failed -> exit code of the first failure, 5": {
+			Args:           []string{"cache", "warm"},
+			Stdin:          "fail-1\nfail-2\n",
+			ExpectOutput:   []string{"2 of 2 queries failed."},
+			ExpectExitCode: cmd.ExitUpstream,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+			defer cancel()
+
+			apiKey, apiUrl := "stub-key", stub.URL
+			if tc.ApiKey != nil {
+				apiKey = *tc.ApiKey
+			}
+			if tc.ApiUrl != "" {
+				apiUrl = tc.ApiUrl
+			}
+
+			geoCmd := exec.CommandContext(ctx, "../../build/geo", append([]string{"--retry-attempts", "1"}, tc.Args...)...)
+			geoCmd.Stdin = strings.NewReader(tc.Stdin)
+			geoCmd.Env = []string{
+				fmt.Sprintf("%s=%s", cmd.ApiKeyName, apiKey),
+				fmt.Sprintf("%s=%s", cmd.ApiUrlName, apiUrl),
+				fmt.Sprintf("XDG_CACHE_HOME=%s", t.TempDir()),
+			}
+
+			outStr, err := geoCmd.CombinedOutput()
+			if code := internaltesting.ExitCode(t, err); code != tc.ExpectExitCode {
+				t.Logf("unexpected exit code %d, expected %d\n%s", code, tc.ExpectExitCode, outStr)
 				t.Fail()
 			}
 